@@ -0,0 +1,96 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ConcurrencyPolicy describes how a CronRun treats the Runs it created earlier that are still active.
+// +kubebuilder:validation:Enum=Allow;Forbid;Replace
+type ConcurrencyPolicy string
+
+const (
+	// AllowConcurrent allows Runs to be created while earlier Runs are still active. This is the default.
+	AllowConcurrent ConcurrencyPolicy = "Allow"
+	// ForbidConcurrent skips a scheduled Run while an earlier Run is still active.
+	ForbidConcurrent ConcurrencyPolicy = "Forbid"
+	// ReplaceConcurrent cancels the active Runs, and creates the scheduled Run once they have finished.
+	ReplaceConcurrent ConcurrencyPolicy = "Replace"
+)
+
+// CronRunSpec defines the desired state of CronRun
+type CronRunSpec struct {
+	// Schedule is a standard cron expression, such as "0 2 * * *", or a descriptor such as "@daily"
+	Schedule      string `json:"schedule"`
+	WorkspaceName string `json:"workspaceName"`
+	// Type, Targets and Replace are copied into the spec of every Run created.
+	Type    RunType  `json:"type,omitempty"`
+	Targets []string `json:"targets,omitempty"`
+	Replace []string `json:"replace,omitempty"`
+	// StartingDeadlineSeconds is how late a Run may be created after its scheduled time. Runs that missed their
+	// deadline are skipped.
+	// +kubebuilder:validation:Minimum=0
+	StartingDeadlineSeconds *int64 `json:"startingDeadlineSeconds,omitempty"`
+	// ConcurrencyPolicy is one of Allow, Forbid and Replace
+	ConcurrencyPolicy ConcurrencyPolicy `json:"concurrencyPolicy,omitempty"`
+	// Suspend stops new Runs from being created. Runs that were already created are not affected.
+	Suspend *bool `json:"suspend,omitempty"`
+	// SuccessfulRunsHistoryLimit is the number of succeeded Runs to keep. Defaults to 3.
+	// +kubebuilder:validation:Minimum=0
+	SuccessfulRunsHistoryLimit *int32 `json:"successfulRunsHistoryLimit,omitempty"`
+	// FailedRunsHistoryLimit is the number of failed Runs to keep. Defaults to 1.
+	// +kubebuilder:validation:Minimum=0
+	FailedRunsHistoryLimit *int32 `json:"failedRunsHistoryLimit,omitempty"`
+}
+
+// CronRunStatus defines the observed state of CronRun
+type CronRunStatus struct {
+	// Active lists the Runs created by the CronRun that have not finished yet
+	Active []corev1.ObjectReference `json:"active,omitempty"`
+	// LastScheduleTime is when the last Run was scheduled
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Schedule", type=string,JSONPath=`.spec.schedule`
+// +kubebuilder:printcolumn:name="Workspace", type=string,JSONPath=`.spec.workspaceName`
+// +kubebuilder:printcolumn:name="Last Schedule", type="date",JSONPath=`.status.lastScheduleTime`
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// CronRun is the Schema for the cronruns API
+type CronRun struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CronRunSpec   `json:"spec,omitempty"`
+	Status CronRunStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// CronRunList contains a list of CronRun
+type CronRunList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []CronRun `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&CronRun{}, &CronRunList{})
+}
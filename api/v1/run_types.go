@@ -22,12 +22,83 @@ import (
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
+// RunMode describes whether a Run applies its plan right away, only plans, or waits for approval before applying.
+// +kubebuilder:validation:Enum=apply;plan;plan-and-wait-for-approval
+type RunMode string
+
+const (
+	// RunModeApply plans and applies in a single job. This is the default when no mode is set.
+	RunModeApply RunMode = "apply"
+	// RunModePlan saves a plan and stops without applying it.
+	RunModePlan RunMode = "plan"
+	// RunModeApproval saves a plan and waits in the AwaitingApproval phase until spec.approved is set, after which
+	// the saved plan is applied by a second job.
+	RunModeApproval RunMode = "plan-and-wait-for-approval"
+)
+
+// RunType selects the Terraform operation a Run performs.
+// +kubebuilder:validation:Enum=apply;destroy;refresh-only;import;rollback;force-unlock
+type RunType string
+
+const (
+	// RunTypeApply plans and applies changes, as selected by the run mode. This is the default when no type is set.
+	RunTypeApply RunType = "apply"
+	// RunTypeDestroy destroys the resources of the workspace.
+	RunTypeDestroy RunType = "destroy"
+	// RunTypeRefreshOnly updates the state to match the real resources without changing them.
+	RunTypeRefreshOnly RunType = "refresh-only"
+	// RunTypeImport imports existing resources into the state of the workspace.
+	RunTypeImport RunType = "import"
+	// RunTypeRollback restores an earlier version of the state of the workspace, without changing any resources.
+	RunTypeRollback RunType = "rollback"
+	// RunTypeForceUnlock releases a lock left held on the state of the workspace, without changing the state.
+	RunTypeForceUnlock RunType = "force-unlock"
+)
+
+// ImportResource pairs a resource address with the ID of the existing resource to import into it
+type ImportResource struct {
+	Address string `json:"address"`
+	ID      string `json:"id"`
+}
+
 // RunSpec defines the desired state of Run
 type RunSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
-	WorkspaceName   string `json:"workspaceName"`
-	DestroyResource bool   `json:"destroyResource,omitempty"`
+	WorkspaceName string `json:"workspaceName"`
+	// DestroyResource is deprecated, use type: destroy instead. Takes precedence over type when set.
+	DestroyResource bool `json:"destroyResource,omitempty"`
+	// Type selects between apply, destroy, refresh-only, import, rollback and force-unlock.
+	Type RunType `json:"type,omitempty"`
+	// Mode selects between apply, plan and plan-and-wait-for-approval. Only used by apply runs.
+	Mode RunMode `json:"mode,omitempty"`
+	// Approved allows a Run in plan-and-wait-for-approval mode to apply its saved plan.
+	Approved bool `json:"approved,omitempty"`
+	// ApprovalTimeoutSeconds cancels a Run in plan-and-wait-for-approval mode that has not been approved this many
+	// seconds after its plan completed, as it holds the queue of its workspace until then. Defaults to the approval
+	// timeout the controller is configured with.
+	// +kubebuilder:validation:Minimum=1
+	ApprovalTimeoutSeconds *int32 `json:"approvalTimeoutSeconds,omitempty"`
+	// Targets limits planning and destroying to the given resource or module addresses, as with -target.
+	Targets []string `json:"targets,omitempty"`
+	// Replace forces the replacement of the given resource instances, as with -replace. Ignored when destroying.
+	Replace []string `json:"replace,omitempty"`
+	// Imports lists the resources an import run imports.
+	Imports []ImportResource `json:"imports,omitempty"`
+	// StateVersion is the ID of the version in the stateHistory of the workspace that a rollback run restores.
+	StateVersion string `json:"stateVersion,omitempty"`
+	// LockID is the ID of the state lock a force-unlock run releases, as listed in the stateLock of the workspace.
+	LockID string `json:"lockID,omitempty"`
+	// TTLSecondsAfterFinished deletes the Run, along with its Jobs, pods and ConfigMaps, this many seconds after it
+	// finishes. Defaults to the TTL the controller is configured with.
+	// +kubebuilder:validation:Minimum=0
+	TTLSecondsAfterFinished *int32 `json:"ttlSecondsAfterFinished,omitempty"`
+	// Timeout limits how long each job of the Run may be active, e.g. "30m". Terraform is interrupted when it runs
+	// out, and the Run fails.
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// Cancel interrupts Terraform, giving it the chance to release the state lock and write partial state, and stops
+	// the Run.
+	Cancel bool `json:"cancel,omitempty"`
 }
 
 // RunStatus defines the observed state of Run
@@ -37,12 +108,80 @@ type RunStatus struct {
 	Phase        ObjectPhase `json:"phase"`
 	Reason       string      `json:"reason"`
 	JobCompleted bool        `json:"jobCompleted"`
+	// PlanCompleted is set once the plan job of a plan or plan-and-wait-for-approval Run has succeeded.
+	PlanCompleted bool `json:"planCompleted,omitempty"`
+	// PlanSummary describes the changes planned by the run
+	PlanSummary *PlanSummary `json:"planSummary,omitempty"`
+	// AwaitingApprovalTime is when the run started waiting for approval
+	AwaitingApprovalTime *metav1.Time `json:"awaitingApprovalTime,omitempty"`
+	// QueuePosition is the number of unfinished runs against the same workspace ahead of a Queued run
+	QueuePosition int `json:"queuePosition,omitempty"`
+	// CompletionTime is when the run last reached a terminal phase
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// Logs tells where the Terraform logs of the finished pods of the run were stored
+	Logs []LogReference `json:"logs,omitempty"`
+	// Attempt counts the executions of the run. Editing the spec, or changing the terraform.scipian.io/rerun
+	// annotation, starts a new attempt with fresh jobs once the current one has finished.
+	Attempt int `json:"attempt,omitempty"`
+	// SpecHash is the hash of the spec fields the current attempt executes
+	SpecHash string `json:"specHash,omitempty"`
+	// Rerun is the value of the rerun annotation when the current attempt started
+	Rerun string `json:"rerun,omitempty"`
+	// PreviousAttempts records the outcome of the most recent earlier attempts, oldest first
+	PreviousAttempts []RunAttempt `json:"previousAttempts,omitempty"`
+	// SourceRevision is the commit, or the SHA-256 digest of the archive, the run fetched from the workspace source
+	SourceRevision string `json:"sourceRevision,omitempty"`
+}
+
+// RunAttempt records the outcome of an earlier attempt of a Run
+type RunAttempt struct {
+	Attempt        int            `json:"attempt"`
+	Phase          ObjectPhase    `json:"phase"`
+	Reason         string         `json:"reason"`
+	CompletionTime *metav1.Time   `json:"completionTime,omitempty"`
+	PlanSummary    *PlanSummary   `json:"planSummary,omitempty"`
+	Logs           []LogReference `json:"logs,omitempty"`
+	SourceRevision string         `json:"sourceRevision,omitempty"`
+}
+
+// LogSinkType is the kind of storage the Terraform logs of Run pods are kept in
+type LogSinkType string
+
+// Valid log sinks
+const (
+	// ConfigMapLogSink splits the logs across ConfigMaps owned by the Run
+	ConfigMapLogSink LogSinkType = "configmap"
+	// DirectoryLogSink writes the logs to a directory of the controller, such as a mounted PersistentVolumeClaim
+	DirectoryLogSink LogSinkType = "directory"
+	// S3LogSink uploads the logs to an S3 (or S3-compatible) bucket, next to the state of the Workspace
+	S3LogSink LogSinkType = "s3"
+)
+
+// LogReference tells where the Terraform log of a Run pod was stored
+type LogReference struct {
+	Pod  string      `json:"pod"`
+	Sink LogSinkType `json:"sink"`
+	// Location is the name prefix of the ConfigMaps, the path of the file, or the s3:// URL holding the log
+	Location string `json:"location"`
+	// Chunks is the number of ConfigMaps, named <location>-0 and up, the log is split across
+	Chunks int `json:"chunks,omitempty"`
+}
+
+// PlanSummary describes the changes a Terraform plan makes, as reported by `terraform show -json`
+type PlanSummary struct {
+	Add     int `json:"add"`
+	Change  int `json:"change"`
+	Destroy int `json:"destroy"`
+	// Addresses lists the addresses of the resources the plan creates, updates, replaces or destroys
+	Addresses []string `json:"addresses,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:printcolumn:name="Status", type=string,JSONPath=`.status.phase`
 // +kubebuilder:printcolumn:name="Reason", type=string,JSONPath=`.status.reason`
+// +kubebuilder:printcolumn:name="Queue Position",type=integer,JSONPath=`.status.queuePosition`,priority=1
+// +kubebuilder:printcolumn:name="Attempt",type=integer,JSONPath=`.status.attempt`,priority=1
 // +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
 // Run is the Schema for the runs API
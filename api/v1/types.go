@@ -15,6 +15,11 @@ limitations under the License.
 
 package v1
 
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
 // ObjectPhase is a label for the condition of different scipian objects(Workspace and Run) at the current time.
 type ObjectPhase string
 
@@ -43,12 +48,25 @@ const (
 	// RunDestroying is similar to ObjRunning and is applicable only while creating a run object with
 	// destroyResource: True.
 	RunDestroying ObjectPhase = "Destroying"
+	// RunAwaitingApproval means that the plan job of a run in plan-and-wait-for-approval mode has completed and the
+	// saved plan will not be applied until the run is approved.
+	RunAwaitingApproval ObjectPhase = "AwaitingApproval"
+	// RunQueued means that the run is waiting for earlier runs against the same workspace to finish before its
+	// job is created.
+	RunQueued ObjectPhase = "Queued"
+	// RunCancelling means that the run was cancelled and Terraform has been interrupted, but its pod has not
+	// terminated yet.
+	RunCancelling ObjectPhase = "Cancelling"
+	// RunCancelled means that the run was cancelled, and Terraform was interrupted before it finished if it was
+	// running.
+	RunCancelled ObjectPhase = "Cancelled"
 )
 
 // Valid status reasons for scipian objects (Workspace and Run)
 const (
 	PendingJobCreation = "PendingJobCreation"
 	ErrRetriveTfstate  = "ErrRetriveTfstate"
+	ErrStoreTfstate    = "ErrStoreTfstate"
 	RunSucceeded       = "RunSucceeded"
 	JobCompleted       = "JobCompleted"
 	JobFailed          = "JobFailed"
@@ -60,4 +78,47 @@ const (
 	ErrImagePull       = "ErrImagePull"
 	ImagePullBackOff   = "ImagePullBackOff"
 	WorkspaceCreated   = "WorkspaceCreated"
+	PlanCompleted      = "PlanCompleted"
+	ErrExportOutputs   = "ErrExportOutputs"
+	InvalidAddress     = "InvalidAddress"
+	InvalidImport      = "InvalidImport"
+	InvalidVersion     = "InvalidVersion"
+	InvalidLockID      = "InvalidLockID"
+	StateLocked        = "StateLocked"
+	InvalidSchedule    = "InvalidSchedule"
+	DriftDetected      = "DriftDetected"
+	NoDriftDetected    = "NoDriftDetected"
+	DriftCheckFailed   = "DriftCheckFailed"
+	WaitingForRun      = "WaitingForRun"
+	WaitingForDrift    = "WaitingForDrift"
+	PendingJobDeletion = "PendingJobDeletion"
+	ApplyInProgress    = "ApplyInProgress"
+	ApplySucceeded     = "ApplySucceeded"
+	ApplyFailed        = "ApplyFailed"
+	TimedOut           = "TimedOut"
+	CancelRequested    = "CancelRequested"
+	CancelCompleted    = "CancelCompleted"
+	ApprovalExpired    = "ApprovalExpired"
 )
+
+// ConditionType is the type of a Condition
+type ConditionType string
+
+// Valid condition types for scipian objects
+const (
+	// WorkspaceDrifted is True when the last scheduled drift check found changes made outside of Terraform, or
+	// changes to the configuration that have not been applied yet.
+	WorkspaceDrifted ConditionType = "Drifted"
+	// WorkspaceApplied is True when the current spec of a Workspace in autoApply mode has been applied, Unknown while
+	// it is being applied, and False when applying it failed.
+	WorkspaceApplied ConditionType = "Applied"
+)
+
+// Condition describes an aspect of the state of a scipian object at a certain point in time
+type Condition struct {
+	Type               ConditionType          `json:"type"`
+	Status             corev1.ConditionStatus `json:"status"`
+	Reason             string                 `json:"reason,omitempty"`
+	Message            string                 `json:"message,omitempty"`
+	LastTransitionTime metav1.Time            `json:"lastTransitionTime,omitempty"`
+}
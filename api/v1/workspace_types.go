@@ -16,6 +16,10 @@ limitations under the License.
 package v1
 
 import (
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -26,13 +30,168 @@ import (
 type WorkspaceSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
-	Image      string            `json:"image"`
-	Secret     string            `json:"secret"`
-	WorkingDir string            `json:"workingDir"`
+	Image string `json:"image"`
+	// Secret holds the aws_access_key_id and aws_secret_access_key the Terraform providers use. It can be left out
+	// when they get credentials from the web identity of ServiceAccountName instead.
+	Secret string `json:"secret,omitempty"`
+	// ServiceAccountName is the ServiceAccount the jobs run as, such as one annotated with an IAM role for service
+	// accounts (IRSA) whose web identity the providers get credentials from
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+	// AssumeRoleARN is an IAM role the Terraform providers assume, with the credentials of Secret or of the web
+	// identity of ServiceAccountName
+	AssumeRoleARN string `json:"assumeRoleARN,omitempty"`
+	// ExternalID is passed when assuming AssumeRoleARN
+	ExternalID string `json:"externalID,omitempty"`
+	// WorkingDir is the directory Terraform runs in. It is ignored when the configuration is fetched from a Source.
+	// +optional
+	WorkingDir string            `json:"workingDir,omitempty"`
 	Region     string            `json:"region"`
 	EnvVars    map[string]string `json:"envVars,omitempty"`
-	TfVars     map[string]string `json:"tfVars,omitempty"`
-	TfState    string            `json:"state,omitempty"`
+	// TfVars are Terraform variables of any type: strings, numbers, bools, lists and maps are given as JSON values
+	TfVars map[string]TfVar `json:"tfVars,omitempty"`
+	// Deprecated: TfState is no longer written. The controller moves state found here into the Secrets referenced by
+	// status.state and clears it.
+	TfState string `json:"state,omitempty"`
+	// Source fetches the Terraform configuration from a Git repository or an HTTP archive before every job, instead of
+	// using the configuration built into the image
+	Source *Source `json:"source,omitempty"`
+	// Backend is where Terraform keeps the state of the workspace. It defaults to the backend of the controller.
+	Backend *Backend `json:"backend,omitempty"`
+	// Variables are Terraform variables given inline or read from a Secret or ConfigMap key. Variables read from
+	// Secrets reach the Terraform jobs through a Secret, rather than the ConfigMap holding tfVars.
+	Variables []Variable `json:"variables,omitempty"`
+	// Outputs configures where the Terraform outputs of the workspace are written after every successful run
+	Outputs *OutputsSink `json:"outputs,omitempty"`
+	// DriftDetection schedules plan-only checks that report changes made outside of Terraform
+	DriftDetection *DriftDetection `json:"driftDetection,omitempty"`
+	// AutoApply plans and applies the workspace again whenever its image, working directory, region, secret,
+	// environment variables or Terraform variables change, through a Run named <workspace>-apply-<spec hash>
+	AutoApply bool `json:"autoApply,omitempty"`
+}
+
+// Source is where the Terraform configuration of a workspace is fetched from. Exactly one of Git and HTTP is set.
+type Source struct {
+	Git  *GitSource  `json:"git,omitempty"`
+	HTTP *HTTPSource `json:"http,omitempty"`
+}
+
+// GitSource is a Git repository holding Terraform configuration
+type GitSource struct {
+	// URL is the repository to clone
+	URL string `json:"url"`
+	// Ref is the branch, tag or commit to check out. The default branch of the repository is checked out by default.
+	Ref string `json:"ref,omitempty"`
+	// Path is the directory of the configuration within the repository
+	Path string `json:"path,omitempty"`
+}
+
+// HTTPSource is a gzipped tarball of Terraform configuration
+type HTTPSource struct {
+	// URL is where the tarball is downloaded from
+	URL string `json:"url"`
+	// Path is the directory of the configuration within the tarball
+	Path string `json:"path,omitempty"`
+}
+
+// Backend is where Terraform keeps the state of a workspace. Exactly one backend is set. Its settings left empty
+// default to those of the controller.
+type Backend struct {
+	S3         *S3Backend         `json:"s3,omitempty"`
+	Kubernetes *KubernetesBackend `json:"kubernetes,omitempty"`
+	HTTP       *HTTPBackend       `json:"http,omitempty"`
+	Local      *LocalBackend      `json:"local,omitempty"`
+}
+
+// S3Backend keeps state in an S3 bucket, or in a bucket of an S3-compatible store such as MinIO
+type S3Backend struct {
+	// Bucket defaults to the bucket of the controller. Any other bucket must be allowed by the controller.
+	Bucket string `json:"bucket,omitempty"`
+	Region string `json:"region,omitempty"`
+	// DynamoDBTable locks the state. It defaults to the bucket name with -locking appended, except for custom
+	// endpoints, which are not locked by default.
+	DynamoDBTable string `json:"dynamodbTable,omitempty"`
+	// Endpoint is the URL of an S3-compatible store, whose buckets are addressed by path
+	Endpoint string `json:"endpoint,omitempty"`
+	// RoleARN is an IAM role assumed to access the state, by the controller and by Terraform. It defaults to the role
+	// of the controller. Any other role must be allowed by the controller.
+	RoleARN string `json:"roleARN,omitempty"`
+	// ExternalID is passed when assuming RoleARN
+	ExternalID string `json:"externalID,omitempty"`
+}
+
+// KubernetesBackend keeps state in a Secret in the namespace of the workspace, locked through a Lease. The service
+// account of the Terraform jobs needs access to both.
+type KubernetesBackend struct {
+	// SecretSuffix names the Secret tfstate-<workspace>-<suffix>. It defaults to "state".
+	SecretSuffix string `json:"secretSuffix,omitempty"`
+}
+
+// HTTPBackend keeps state behind a REST endpoint. The backend has no Terraform workspaces, so the namespace and name
+// of the workspace are appended to its addresses.
+type HTTPBackend struct {
+	Address string `json:"address,omitempty"`
+	// LockAddress locks the state, and defaults to not locking it
+	LockAddress string `json:"lockAddress,omitempty"`
+	// UnlockAddress defaults to the lock address
+	UnlockAddress string `json:"unlockAddress,omitempty"`
+}
+
+// LocalBackend keeps state on a PersistentVolumeClaim in the namespace of the workspace, mounted into its jobs
+type LocalBackend struct {
+	ClaimName string `json:"claimName,omitempty"`
+}
+
+// TfVar is the value of a Terraform variable: a string, number, bool, list or map, kept as the JSON it was given as
+// +kubebuilder:validation:Type=""
+type TfVar struct {
+	Raw []byte `json:"-"`
+}
+
+// MarshalJSON returns the JSON value of the variable
+func (v TfVar) MarshalJSON() ([]byte, error) {
+	if len(v.Raw) == 0 {
+		return []byte("null"), nil
+	}
+	return v.Raw, nil
+}
+
+// UnmarshalJSON keeps a copy of any JSON value as the value of the variable
+func (v *TfVar) UnmarshalJSON(data []byte) error {
+	if !json.Valid(data) {
+		return fmt.Errorf("invalid JSON value %q", data)
+	}
+	v.Raw = append(v.Raw[:0], data...)
+	return nil
+}
+
+// Variable is a Terraform variable whose value is given inline or read from a Secret or ConfigMap key
+type Variable struct {
+	Name      string          `json:"name"`
+	Value     string          `json:"value,omitempty"`
+	ValueFrom *VariableSource `json:"valueFrom,omitempty"`
+}
+
+// VariableSource selects the Secret or ConfigMap key, in the namespace of the Workspace, a variable is read from
+type VariableSource struct {
+	SecretKeyRef    *corev1.SecretKeySelector    `json:"secretKeyRef,omitempty"`
+	ConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+}
+
+// DriftDetection configures the scheduled drift checks of a Workspace
+type DriftDetection struct {
+	// Schedule is a standard cron expression, such as "0 */6 * * *", or a descriptor such as "@hourly"
+	Schedule string `json:"schedule"`
+}
+
+// OutputsSink names the Secret and/or ConfigMap that receive the Terraform outputs of a Workspace. Both are
+// created in the namespace of the Workspace and owned by it.
+type OutputsSink struct {
+	// SecretName is the Secret receiving sensitive outputs, and all other outputs when no ConfigMapName is set
+	SecretName string `json:"secretName,omitempty"`
+	// ConfigMapName is the ConfigMap receiving outputs that are not sensitive
+	ConfigMapName string `json:"configMapName,omitempty"`
+	// Keys maps output names to the keys they are stored under. Outputs not listed are stored under their own name.
+	Keys map[string]string `json:"keys,omitempty"`
 }
 
 // WorkspaceStatus defines the observed state of Workspace
@@ -42,12 +201,91 @@ type WorkspaceStatus struct {
 	Phase        ObjectPhase `json:"phase"`
 	Reason       string      `json:"reason"`
 	JobCompleted bool        `json:"jobCompleted"`
+	Conditions   []Condition `json:"conditions,omitempty"`
+	// DriftedResources lists the addresses of the resources the last drift check found changes for
+	DriftedResources []string `json:"driftedResources,omitempty"`
+	// LastDriftCheck is when the last drift check was started
+	LastDriftCheck *metav1.Time `json:"lastDriftCheck,omitempty"`
+	// LastAppliedHash is the hash of the spec last applied in autoApply mode
+	LastAppliedHash string `json:"lastAppliedHash,omitempty"`
+	// ApplyHash is the hash of the spec the latest autoApply Run was created for
+	ApplyHash string `json:"applyHash,omitempty"`
+	// SourceRevision is the commit, or the SHA-256 digest of the archive, the last job fetched from the source
+	SourceRevision string `json:"sourceRevision,omitempty"`
+	// State locates the copy of the Terraform state the controller keeps after every successful job
+	State *StateReference `json:"state,omitempty"`
+	// StateSummary describes the state last retrieved, without any of the values it holds
+	StateSummary *StateSummary `json:"stateSummary,omitempty"`
+	// StateHistory lists the most recent versions of the state, newest first, which rollback Runs can restore
+	StateHistory []StateVersion `json:"stateHistory,omitempty"`
+	// StateLock is the lock last found held on the state after a job failed. It is cleared once a job succeeds.
+	StateLock *StateLock `json:"stateLock,omitempty"`
+}
+
+// StateLock describes a lock held on the state of a workspace, as Terraform reports it
+type StateLock struct {
+	// ID is the ID of the lock, which force-unlock Runs take
+	ID string `json:"id"`
+	// Path is the state the lock is held on
+	Path string `json:"path,omitempty"`
+	// Operation is the Terraform operation holding the lock, such as OperationTypeApply
+	Operation string `json:"operation,omitempty"`
+	// Who is the user and host holding the lock. For jobs of the controller, the host is their pod.
+	Who string `json:"who,omitempty"`
+	// Version is the version of Terraform holding the lock
+	Version string `json:"version,omitempty"`
+	// Created is when the lock was taken
+	Created *metav1.Time `json:"created,omitempty"`
+}
+
+// StateVersion is a version of the state of a workspace
+type StateVersion struct {
+	// ID is the S3 object version, or the ID of the snapshot the controller keeps of the state
+	ID string `json:"id"`
+	// Serial is the serial of the state
+	Serial int64 `json:"serial"`
+	// Timestamp is when the version was written
+	Timestamp metav1.Time `json:"timestamp"`
+	// Snapshot locates the Secrets the controller keeps the version in, for backends that do not keep versions
+	Snapshot *StateReference `json:"snapshot,omitempty"`
+}
+
+// StateSummary describes a Terraform state
+type StateSummary struct {
+	// Serial is incremented by Terraform every time it writes the state
+	Serial int64 `json:"serial"`
+	// Lineage is the unique ID Terraform gave the state when it was created
+	Lineage string `json:"lineage,omitempty"`
+	// TerraformVersion is the version of Terraform that last wrote the state
+	TerraformVersion string `json:"terraformVersion,omitempty"`
+	// Resources counts the instances of the managed resources in the state, leaving out data sources
+	Resources int `json:"resources"`
+	// Outputs lists the names of the root module outputs
+	Outputs []string `json:"outputs,omitempty"`
+}
+
+// StateReference locates the Terraform state of a workspace, kept in Secrets owned by the workspace
+type StateReference struct {
+	// SecretName is the name prefix of the Secrets, named <secretName>-0 and up, the state is split across
+	SecretName string `json:"secretName"`
+	// Chunks is the number of Secrets the state is split across
+	Chunks int `json:"chunks"`
+	// Compression is "gzip" if the state was compressed before it was split
+	Compression string `json:"compression,omitempty"`
+	// Digest is the SHA-256 digest of the uncompressed state
+	Digest string `json:"digest"`
+	// Size is the size of the uncompressed state in bytes
+	Size int `json:"size"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:printcolumn:name="Status", type=string,JSONPath=`.status.phase`
 // +kubebuilder:printcolumn:name="Reason", type=string,JSONPath=`.status.reason`
+// +kubebuilder:printcolumn:name="Resources",type=integer,JSONPath=`.status.stateSummary.resources`
+// +kubebuilder:printcolumn:name="Serial",type=integer,JSONPath=`.status.stateSummary.serial`,priority=1
+// +kubebuilder:printcolumn:name="Terraform",type=string,JSONPath=`.status.stateSummary.terraformVersion`,priority=1
+// +kubebuilder:printcolumn:name="Locked By",type=string,JSONPath=`.status.stateLock.who`,priority=1
 // +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
 // Workspace is the Schema for the workspaces API
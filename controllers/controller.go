@@ -17,13 +17,21 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"log"
 
 	"github.com/go-logr/logr"
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	"github.com/scipian/terraform-controller/pkg/core"
+	"github.com/scipian/terraform-controller/pkg/state"
+	"github.com/scipian/terraform-controller/pkg/terraform"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
@@ -35,8 +43,14 @@ type Reconciler struct {
 	Log      logr.Logger
 	Scheme   *runtime.Scheme
 	Recorder record.EventRecorder
+	// Clientset is used for API calls the controller-runtime client does not support, such as reading pod logs
+	Clientset kubernetes.Interface
+	// StateStore, if set, is where the state of every workspace is read from, instead of the store of its backend
+	StateStore core.StateStore
 }
 
+// +kubebuilder:rbac:groups=core,resources=pods/log,verbs=get
+
 // GetSecret retrieves a Kubernetes secret and unmarshalls the secret into a corev1.Secret struct
 func (r *Reconciler) GetSecret(key types.NamespacedName, secretObject *corev1.Secret) error {
 	err := r.Get(context.TODO(), key, secretObject)
@@ -46,6 +60,29 @@ func (r *Reconciler) GetSecret(key types.NamespacedName, secretObject *corev1.Se
 	return nil
 }
 
+// IAMCredentials returns the access key and secret key of the Scipian IAM credentials, which are empty if the
+// scipian-aws-iam-creds Secret does not exist and AWS is accessed with the identity of the pod instead
+func (r *Reconciler) IAMCredentials() (string, string, error) {
+	secret := &corev1.Secret{}
+	secretKey := types.NamespacedName{Namespace: core.ScipianNamespace, Name: core.ScipianIAMSecretName}
+	if err := r.GetSecret(secretKey, secret); err != nil {
+		if errors.IsNotFound(err) {
+			return "", "", nil
+		}
+		return "", "", err
+	}
+	return string(secret.Data[core.AccessKey]), string(secret.Data[core.SecretKey]), nil
+}
+
+// GetPodLogs retrieves the logs of the given pod
+func (r *Reconciler) GetPodLogs(key types.NamespacedName) (string, error) {
+	logs, err := r.Clientset.CoreV1().Pods(key.Namespace).GetLogs(key.Name, &corev1.PodLogOptions{}).DoRaw()
+	if err != nil {
+		return "", err
+	}
+	return string(logs), nil
+}
+
 // CreateObject creates a Kubernetes object based on given parameters
 func (r *Reconciler) CreateObject(key types.NamespacedName, createObject runtime.Object, foundObject runtime.Object) error {
 	obj := createObject.GetObjectKind().GroupVersionKind()
@@ -73,6 +110,464 @@ func (r *Reconciler) SetControllerReference(owner, reference v1.Object) error {
 	return nil
 }
 
+// StateStoreFor returns the store the state of a workspace is read from, or nil if the controller does not read the
+// state of its backend
+func (r *Reconciler) StateStoreFor(workspace *terraformv1.Workspace) (core.StateStore, error) {
+	if r.StateStore != nil {
+		return r.StateStore, nil
+	}
+	backend, err := core.ResolveBackend(workspace)
+	if err != nil {
+		return nil, err
+	}
+	var accessKey, secretKey string
+	if backend.S3 != nil {
+		if accessKey, secretKey, err = r.IAMCredentials(); err != nil {
+			return nil, err
+		}
+	}
+	return core.StateStoreFor(backend, r.Client, accessKey, secretKey)
+}
+
+// ReadState returns the current state of a workspace, which is empty if the controller does not read the state of
+// its backend
+func (r *Reconciler) ReadState(workspace *terraformv1.Workspace) (string, error) {
+	store, err := r.StateStoreFor(workspace)
+	if err != nil || store == nil {
+		return "", err
+	}
+	return store.Get(types.NamespacedName{Namespace: workspace.Namespace, Name: workspace.Name}, "")
+}
+
+// SaveState keeps the state of a workspace in Secrets owned by it, and references them, a summary of the state and
+// its recent versions in its status, which the caller updates. Unchanged state is not written again, and Secrets left
+// over from a larger state are deleted.
+func (r *Reconciler) SaveState(workspace *terraformv1.Workspace, tfState string) error {
+	// The state of backends the controller does not read is empty
+	if tfState == "" {
+		return nil
+	}
+	// States written by Terraform before 0.12 are kept without a summary
+	summary, err := state.Summarize([]byte(tfState))
+	if err != nil {
+		log.Printf("Not summarizing tfstate of workspace/%s: %v", workspace.Name, err)
+	}
+	workspace.Status.StateSummary = summary
+
+	compression, err := core.StateCompression()
+	if err != nil {
+		return err
+	}
+	if ref := workspace.Status.State; ref != nil && ref.Digest == core.StateDigest(tfState) && ref.Compression == compression {
+		return nil
+	}
+	secrets, ref, err := core.StateSecrets(workspace, tfState, compression, core.StateChunkSize)
+	if err != nil {
+		return err
+	}
+	if err := r.writeStateSecrets(workspace, secrets); err != nil {
+		return err
+	}
+	saved := map[string]bool{}
+	for _, secret := range secrets {
+		saved[secret.Name] = true
+	}
+	if err := r.pruneStateSecrets(workspace, func(secret *corev1.Secret) bool {
+		return saved[secret.Name] || secret.Labels[core.StateVersionLabel] != ""
+	}); err != nil {
+		return err
+	}
+	workspace.Status.State = &ref
+
+	// The history only helps rolling back, so failing to record it does not fail storing the state
+	if err := r.recordStateHistory(workspace, tfState, compression); err != nil {
+		log.Printf("Not recording tfstate history of workspace/%s: %v", workspace.Name, err)
+	}
+	return nil
+}
+
+// recordStateHistory lists the most recent versions of the state of a workspace in its status. They are taken from
+// the backend if it keeps versions, and otherwise the controller keeps a snapshot of the given state.
+func (r *Reconciler) recordStateHistory(workspace *terraformv1.Workspace, tfState string, compression string) error {
+	limit, err := core.StateHistoryLimit()
+	if err != nil {
+		return err
+	}
+	store, err := r.StateStoreFor(workspace)
+	if err != nil {
+		return err
+	}
+	key := types.NamespacedName{Namespace: workspace.Namespace, Name: workspace.Name}
+	versions, err := core.BackendVersions(store, key)
+	if err != nil {
+		return err
+	}
+
+	var history []terraformv1.StateVersion
+	if versions != nil {
+		for _, version := range versions {
+			if len(history) == limit {
+				break
+			}
+			if known, found := core.FindStateVersion(workspace, version.ID); found {
+				history = append(history, *known)
+				continue
+			}
+			content, err := store.Get(key, version.ID)
+			if err != nil {
+				return err
+			}
+			serial, err := state.Serial([]byte(content))
+			if err != nil {
+				return err
+			}
+			history = append(history, terraformv1.StateVersion{
+				ID:        version.ID,
+				Serial:    serial,
+				Timestamp: v1.NewTime(version.LastModified),
+			})
+		}
+	} else if limit > 0 {
+		serial, err := state.Serial([]byte(tfState))
+		if err != nil {
+			return err
+		}
+		secrets, ref, err := core.StateSnapshotSecrets(workspace, tfState, compression, core.StateChunkSize)
+		if err != nil {
+			return err
+		}
+		if err := r.writeStateSecrets(workspace, secrets); err != nil {
+			return err
+		}
+		history = core.AddSnapshot(workspace.Status.StateHistory, terraformv1.StateVersion{
+			ID:        core.SnapshotID(tfState),
+			Serial:    serial,
+			Timestamp: v1.Now(),
+			Snapshot:  &ref,
+		}, limit)
+	}
+	workspace.Status.StateHistory = history
+
+	snapshots := map[string]bool{}
+	for _, version := range history {
+		if version.Snapshot != nil {
+			snapshots[version.ID] = true
+		}
+	}
+	return r.pruneStateSecrets(workspace, func(secret *corev1.Secret) bool {
+		id := secret.Labels[core.StateVersionLabel]
+		return id == "" || snapshots[id]
+	})
+}
+
+// writeStateSecrets creates or updates Secrets holding the state of a workspace, owned by the workspace
+func (r *Reconciler) writeStateSecrets(workspace *terraformv1.Workspace, secrets []*corev1.Secret) error {
+	for _, chunk := range secrets {
+		chunk := chunk
+		secret := &corev1.Secret{ObjectMeta: v1.ObjectMeta{Name: chunk.Name, Namespace: chunk.Namespace}}
+		if _, err := controllerutil.CreateOrUpdate(context.TODO(), r.Client, secret, func() error {
+			secret.Labels = chunk.Labels
+			secret.Type = chunk.Type
+			secret.Data = chunk.Data
+			return r.SetControllerReference(workspace, secret)
+		}); err != nil {
+			return fmt.Errorf("failed to store state in secret/%s: %v", chunk.Name, err)
+		}
+	}
+	return nil
+}
+
+// pruneStateSecrets deletes the state Secrets owned by a workspace that keep returns false for
+func (r *Reconciler) pruneStateSecrets(workspace *terraformv1.Workspace, keep func(*corev1.Secret) bool) error {
+	found := &corev1.SecretList{}
+	if err := r.List(context.TODO(), found, client.InNamespace(workspace.Namespace),
+		client.MatchingLabels{core.WorkspaceLabel: workspace.Name}); err != nil {
+		return err
+	}
+	for i := range found.Items {
+		secret := &found.Items[i]
+		if !v1.IsControlledBy(secret, workspace) || keep(secret) {
+			continue
+		}
+		if err := r.Delete(context.TODO(), secret); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// readStateSecrets reads a state back from the Secrets of a reference
+func (r *Reconciler) readStateSecrets(namespace string, ref terraformv1.StateReference) (string, error) {
+	var secrets []*corev1.Secret
+	for i := 0; i < ref.Chunks; i++ {
+		secret := &corev1.Secret{}
+		key := types.NamespacedName{Namespace: namespace, Name: fmt.Sprintf("%s-%d", ref.SecretName, i)}
+		if err := r.Get(context.TODO(), key, secret); err != nil {
+			return "", err
+		}
+		secrets = append(secrets, secret)
+	}
+	return core.JoinStateSecrets(ref, secrets)
+}
+
+// LoadState reads the state of a workspace back from the Secrets referenced by its status
+func (r *Reconciler) LoadState(workspace *terraformv1.Workspace) (string, error) {
+	ref := workspace.Status.State
+	if ref == nil {
+		if workspace.Spec.TfState != "" {
+			return workspace.Spec.TfState, nil
+		}
+		return "", core.ErrStateNotFound
+	}
+	return r.readStateSecrets(workspace.Namespace, *ref)
+}
+
+// ReadStateVersion returns a version of the state of a workspace listed in its state history
+func (r *Reconciler) ReadStateVersion(workspace *terraformv1.Workspace, id string) (string, error) {
+	version, found := core.FindStateVersion(workspace, id)
+	if !found {
+		return "", core.ErrStateNotFound
+	}
+	if version.Snapshot != nil {
+		return r.readStateSecrets(workspace.Namespace, *version.Snapshot)
+	}
+	store, err := r.StateStoreFor(workspace)
+	if err != nil {
+		return "", err
+	}
+	if store == nil {
+		return "", core.ErrStateNotFound
+	}
+	return store.Get(types.NamespacedName{Namespace: workspace.Namespace, Name: workspace.Name}, id)
+}
+
+// MigrateState moves state written to the deprecated state field of a workspace spec into Secrets
+func (r *Reconciler) MigrateState(workspace *terraformv1.Workspace) error {
+	if workspace.Spec.TfState == "" {
+		return nil
+	}
+	if workspace.Status.State == nil {
+		if err := r.SaveState(workspace, workspace.Spec.TfState); err != nil {
+			return err
+		}
+		if err := r.Status().Update(context.TODO(), workspace); err != nil {
+			return err
+		}
+	}
+	workspace.Spec.TfState = ""
+	return r.Update(context.TODO(), workspace)
+}
+
+// RecordStateLock records the lock held on the state of a workspace in its status, which the caller updates, after a
+// job failed, and reports whether the job failed to acquire the lock. The lock holder Terraform printed in the logs of
+// the job is recorded if it did. Otherwise the lock table of s3 backends is read, to find locks left behind by jobs
+// that died while holding them. The lock is only recorded on a best effort basis, so failures are logged.
+func (r *Reconciler) RecordStateLock(workspace *terraformv1.Workspace, jobName string) bool {
+	locked := false
+	for _, logs := range r.jobLogs(workspace.Namespace, jobName) {
+		if lock, found := terraform.LockFromLogs(logs); found {
+			locked = true
+			if lock != nil {
+				workspace.Status.StateLock = lock
+				return true
+			}
+		}
+	}
+
+	backend, err := core.ResolveBackend(workspace)
+	if err != nil {
+		log.Printf("Unable to read state lock of workspace/%s: %v", workspace.Name, err)
+		return locked
+	}
+	if backend.S3 == nil || backend.S3.DynamoDBTable == "" {
+		return locked
+	}
+	accessKey, secretKey, err := r.IAMCredentials()
+	if err != nil {
+		log.Printf("Unable to get credentials to read state lock: %v", err)
+		return locked
+	}
+	table := core.LockTableFor(backend, accessKey, secretKey)
+	lock, err := table.Get(types.NamespacedName{Namespace: workspace.Namespace, Name: workspace.Name})
+	if err != nil {
+		log.Printf("Unable to read state lock of workspace/%s: %v", workspace.Name, err)
+		return locked
+	}
+	workspace.Status.StateLock = lock
+	return locked
+}
+
+// lockMessage describes a state lock a job failed to acquire in events
+func lockMessage(lock *terraformv1.StateLock) string {
+	if lock == nil {
+		return "Job failed to acquire the state lock"
+	}
+	return fmt.Sprintf("State locked by %s - lock ID %s", lock.Who, lock.ID)
+}
+
+// jobLogs returns the logs of the finished pods of a job, leaving out those that could not be read
+func (r *Reconciler) jobLogs(namespace string, jobName string) []string {
+	podList := &corev1.PodList{}
+	podLabel := map[string]string{"job-name": jobName}
+	if err := r.List(context.Background(), podList, client.InNamespace(namespace), client.MatchingLabels(podLabel)); err != nil {
+		log.Printf("Unable to list pods of job/%s: %v", jobName, err)
+		return nil
+	}
+	var logs []string
+	for _, pod := range podList.Items {
+		if !podFinished(&pod) {
+			continue
+		}
+		podLogs, err := r.GetPodLogs(types.NamespacedName{Name: pod.Name, Namespace: pod.Namespace})
+		if err != nil {
+			log.Printf("Unable to get logs of pod/%s: %v", pod.Name, err)
+			continue
+		}
+		logs = append(logs, podLogs)
+	}
+	return logs
+}
+
+// ExportOutputs writes the outputs recorded in the given tfstate into the Secret and ConfigMap configured by the
+// Workspace, creating them if needed
+func (r *Reconciler) ExportOutputs(workspace *terraformv1.Workspace, state string) error {
+	sink := workspace.Spec.Outputs
+	// The state of backends the controller does not read is empty
+	if sink == nil || state == "" {
+		return nil
+	}
+	outputs, err := terraform.ParseOutputs(state)
+	if err != nil {
+		return err
+	}
+	secretData, configMapData, err := terraform.FormatOutputs(outputs, sink)
+	if err != nil {
+		return err
+	}
+
+	if sink.SecretName != "" {
+		secret := &corev1.Secret{ObjectMeta: v1.ObjectMeta{Name: sink.SecretName, Namespace: workspace.Namespace}}
+		if _, err := controllerutil.CreateOrUpdate(context.TODO(), r.Client, secret, func() error {
+			secret.Data = make(map[string][]byte)
+			for k, v := range secretData {
+				secret.Data[k] = []byte(v)
+			}
+			return r.SetControllerReference(workspace, secret)
+		}); err != nil {
+			return err
+		}
+	}
+	if sink.ConfigMapName != "" {
+		configMap := &corev1.ConfigMap{ObjectMeta: v1.ObjectMeta{Name: sink.ConfigMapName, Namespace: workspace.Namespace}}
+		if _, err := controllerutil.CreateOrUpdate(context.TODO(), r.Client, configMap, func() error {
+			configMap.Data = configMapData
+			return r.SetControllerReference(workspace, configMap)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResolveVariables reads the values of the variables of a Workspace, including those read from Secret and ConfigMap
+// keys. Missing keys of optional references are left out.
+func (r *Reconciler) ResolveVariables(workspace *terraformv1.Workspace) (terraform.Variables, error) {
+	variables := terraform.Variables{Plain: map[string]string{}, Sensitive: map[string]string{}}
+	for _, variable := range workspace.Spec.Variables {
+		source := variable.ValueFrom
+		switch {
+		case source == nil:
+			variables.Plain[variable.Name] = variable.Value
+		case source.SecretKeyRef != nil && source.ConfigMapKeyRef != nil:
+			return variables, fmt.Errorf("variable %s must be read from either a Secret or a ConfigMap", variable.Name)
+		case source.SecretKeyRef != nil:
+			ref := source.SecretKeyRef
+			secret := &corev1.Secret{}
+			err := r.Get(context.TODO(), types.NamespacedName{Namespace: workspace.Namespace, Name: ref.Name}, secret)
+			if err != nil && !errors.IsNotFound(err) {
+				return variables, err
+			}
+			value, found := secret.Data[ref.Key]
+			if !found {
+				if ref.Optional != nil && *ref.Optional {
+					continue
+				}
+				return variables, fmt.Errorf("variable %s: key %s not found in secret/%s", variable.Name, ref.Key, ref.Name)
+			}
+			variables.Sensitive[variable.Name] = string(value)
+		case source.ConfigMapKeyRef != nil:
+			ref := source.ConfigMapKeyRef
+			configMap := &corev1.ConfigMap{}
+			err := r.Get(context.TODO(), types.NamespacedName{Namespace: workspace.Namespace, Name: ref.Name}, configMap)
+			if err != nil && !errors.IsNotFound(err) {
+				return variables, err
+			}
+			value, found := configMap.Data[ref.Key]
+			if !found {
+				if ref.Optional != nil && *ref.Optional {
+					continue
+				}
+				return variables, fmt.Errorf("variable %s: key %s not found in configmap/%s", variable.Name, ref.Key, ref.Name)
+			}
+			variables.Plain[variable.Name] = value
+		default:
+			return variables, fmt.Errorf("variable %s has an empty valueFrom", variable.Name)
+		}
+	}
+	return variables, nil
+}
+
+// AddVariables resolves the variables of a Workspace into the ConfigMap of a job and, for those read from Secrets,
+// into a Secret owned by owner, which it creates
+func (r *Reconciler) AddVariables(owner v1.Object, workspace *terraformv1.Workspace, job *batchv1.Job, configMap *corev1.ConfigMap) error {
+	variables, err := r.ResolveVariables(workspace)
+	if err != nil {
+		return err
+	}
+	secret, err := terraform.AddVariables(job, configMap, variables)
+	if err != nil || secret == nil {
+		return err
+	}
+	if err := r.SetControllerReference(owner, secret); err != nil {
+		return err
+	}
+	return r.CreateObject(types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}, secret, &corev1.Secret{})
+}
+
+// AddCredentials hands the Scipian IAM credentials to a job through a Secret owned by owner, which it creates unless
+// there are no credentials
+func (r *Reconciler) AddCredentials(owner v1.Object, job *batchv1.Job, backend *terraformv1.Backend, accessKey string, secretKey string) error {
+	secret := terraform.AddCredentials(job, backend, accessKey, secretKey)
+	if secret == nil {
+		return nil
+	}
+	if err := r.SetControllerReference(owner, secret); err != nil {
+		return err
+	}
+	return r.CreateObject(types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}, secret, &corev1.Secret{})
+}
+
+// +kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=get
+
+// AddAssumeRole makes the Terraform providers of a job assume the AssumeRoleARN of a Workspace, if it has one. Without
+// a Secret the role is assumed with the web identity of the IAM role its ServiceAccount is annotated with.
+func (r *Reconciler) AddAssumeRole(workspace *terraformv1.Workspace, job *batchv1.Job, configMap *corev1.ConfigMap) error {
+	if workspace.Spec.AssumeRoleARN == "" {
+		return nil
+	}
+	webIdentityRoleARN := ""
+	if workspace.Spec.Secret == "" && workspace.Spec.ServiceAccountName != "" {
+		serviceAccount := &corev1.ServiceAccount{}
+		key := types.NamespacedName{Namespace: workspace.Namespace, Name: workspace.Spec.ServiceAccountName}
+		if err := r.Get(context.TODO(), key, serviceAccount); err != nil {
+			return fmt.Errorf("Error getting service account %s - %s", key.Name, err)
+		}
+		webIdentityRoleARN = serviceAccount.Annotations[terraform.WebIdentityRoleAnnotation]
+	}
+	terraform.AddAssumeRole(job, configMap, workspace, webIdentityRoleARN)
+	return nil
+}
+
 func ignoreNotFound(err error) error {
 	return client.IgnoreNotFound(err)
 }
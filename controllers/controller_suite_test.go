@@ -23,6 +23,7 @@ import (
 	. "github.com/onsi/gomega"
 
 	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -73,26 +74,42 @@ var _ = BeforeSuite(func(done Done) {
 	})
 	Expect(err).NotTo(HaveOccurred(), "failed to create manager")
 
+	clientset, err := kubernetes.NewForConfig(cfg)
+	Expect(err).NotTo(HaveOccurred(), "failed to create clientset")
+
 	err = (&WorkspaceReconciler{
 		Reconciler: Reconciler{
-			Client:   mgr.GetClient(),
-			Scheme:   scheme.Scheme,
-			Log:      logf.Log,
-			Recorder: mgr.GetEventRecorderFor("workspace-controller"),
+			Client:    mgr.GetClient(),
+			Scheme:    scheme.Scheme,
+			Log:       logf.Log,
+			Recorder:  mgr.GetEventRecorderFor("workspace-controller"),
+			Clientset: clientset,
 		},
 	}).SetupWithManager(mgr)
 	Expect(err).NotTo(HaveOccurred(), "failed to setup Workspace controller")
 
 	err = (&RunReconciler{
 		Reconciler: Reconciler{
-			Client:   mgr.GetClient(),
-			Scheme:   scheme.Scheme,
-			Log:      logf.Log,
-			Recorder: mgr.GetEventRecorderFor("run-controller"),
+			Client:    mgr.GetClient(),
+			Scheme:    scheme.Scheme,
+			Log:       logf.Log,
+			Recorder:  mgr.GetEventRecorderFor("run-controller"),
+			Clientset: clientset,
 		},
 	}).SetupWithManager(mgr)
 	Expect(err).NotTo(HaveOccurred(), "failed to setup Run controller")
 
+	err = (&CronRunReconciler{
+		Reconciler: Reconciler{
+			Client:    mgr.GetClient(),
+			Scheme:    scheme.Scheme,
+			Log:       logf.Log,
+			Recorder:  mgr.GetEventRecorderFor("cronrun-controller"),
+			Clientset: clientset,
+		},
+	}).SetupWithManager(mgr)
+	Expect(err).NotTo(HaveOccurred(), "failed to setup CronRun controller")
+
 	go func() {
 		err := mgr.Start(ctrl.SetupSignalHandler())
 		Expect(err).NotTo(HaveOccurred(), "failed to start manager")
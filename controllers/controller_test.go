@@ -21,10 +21,14 @@ import (
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	"github.com/scipian/terraform-controller/pkg/core"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
@@ -120,5 +124,49 @@ var _ = Describe("Terraform Controller shared functions", func() {
 				return r.CreateObject(objectKey, toCreate, found)
 			}, timeout, interval).Should(BeNil())
 		})
+
+		It("SaveState and LoadState", func() {
+			r := &Reconciler{Client: k8sClient, Scheme: scheme.Scheme, StateStore: &core.MemoryStateStore{}}
+			workspace := &terraformv1.Workspace{
+				ObjectMeta: metav1.ObjectMeta{Name: "state-test", Namespace: objectNamespace, UID: "state-test"},
+			}
+			state := `{"version": 4, "serial": 1}`
+
+			By("Storing the state in a Secret owned by the workspace")
+			Expect(r.SaveState(workspace, state)).To(Succeed())
+			Expect(workspace.Status.State.SecretName).To(Equal("state-test-tfstate"))
+			Expect(workspace.Status.State.Digest).To(Equal(core.StateDigest(state)))
+			Expect(workspace.Status.StateSummary.Serial).To(Equal(int64(1)))
+			secret := &corev1.Secret{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: objectNamespace, Name: "state-test-tfstate-0"}, secret)).To(Succeed())
+			Expect(metav1.IsControlledBy(secret, workspace)).To(BeTrue())
+			Expect(r.LoadState(workspace)).To(Equal(state))
+
+			By("Deleting the Secrets of a larger earlier state")
+			stale := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+				Name:      "state-test-tfstate-1",
+				Namespace: objectNamespace,
+				Labels:    map[string]string{core.WorkspaceLabel: workspace.Name},
+			}}
+			Expect(r.SetControllerReference(workspace, stale)).To(Succeed())
+			Expect(k8sClient.Create(ctx, stale)).To(Succeed())
+			state = `{"version": 4, "serial": 2}`
+			Expect(r.SaveState(workspace, state)).To(Succeed())
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, types.NamespacedName{Namespace: objectNamespace, Name: stale.Name}, stale))).To(BeTrue())
+			Expect(r.LoadState(workspace)).To(Equal(state))
+
+			By("Keeping snapshots of earlier versions")
+			Expect(workspace.Status.StateHistory).To(HaveLen(2))
+			Expect(workspace.Status.StateHistory[0].Serial).To(Equal(int64(2)))
+			earlier := workspace.Status.StateHistory[1]
+			Expect(earlier.Serial).To(Equal(int64(1)))
+			Expect(r.ReadStateVersion(workspace, earlier.ID)).To(Equal(`{"version": 4, "serial": 1}`))
+
+			secrets := &corev1.SecretList{}
+			Expect(k8sClient.List(ctx, secrets, client.InNamespace(objectNamespace), client.MatchingLabels{core.WorkspaceLabel: workspace.Name})).To(Succeed())
+			for i := range secrets.Items {
+				Expect(k8sClient.Delete(ctx, &secrets.Items[i])).To(Succeed())
+			}
+		})
 	})
 })
@@ -0,0 +1,243 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	"github.com/scipian/terraform-controller/pkg/core"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ref "k8s.io/client-go/tools/reference"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// scheduledTimeAnnotation records on a Run the time it was scheduled for by its CronRun
+	scheduledTimeAnnotation = "terraform.scipian.io/scheduled-at"
+
+	// runOwnerKey indexes Runs by the name of the CronRun controlling them
+	runOwnerKey = ".metadata.controller"
+
+	defaultSuccessfulRunsHistoryLimit = 3
+	defaultFailedRunsHistoryLimit     = 1
+)
+
+// CronRunReconciler reconciles a CronRun object
+type CronRunReconciler struct {
+	Reconciler
+}
+
+// +kubebuilder:rbac:groups=terraform.scipian.io,resources=cronruns,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=terraform.scipian.io,resources=cronruns/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=terraform.scipian.io,resources=runs,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile is the reconciler function for CronRun Custom Resources
+func (r *CronRunReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	cronRun := &terraformv1.CronRun{}
+	ctx := context.Background()
+	log := r.Log.WithValues("cronrun", req.NamespacedName)
+
+	if err := r.Get(ctx, req.NamespacedName, cronRun); err != nil {
+		if !errors.IsNotFound(err) {
+			log.Error(err, "unable to GET CronRun")
+		}
+		return ctrl.Result{}, ignoreNotFound(err)
+	}
+
+	runList := &terraformv1.RunList{}
+	if err := r.List(ctx, runList, client.InNamespace(req.Namespace), client.MatchingField(runOwnerKey, req.Name)); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Sort the Runs created by the CronRun into active, successful and failed ones
+	var activeRuns, successfulRuns, failedRuns []terraformv1.Run
+	var lastScheduleTime *time.Time
+	for _, run := range runList.Items {
+		switch run.Status.Phase {
+		case terraformv1.ObjSucceeded:
+			successfulRuns = append(successfulRuns, run)
+		case terraformv1.ObjFailed, terraformv1.ObjIncomplete, terraformv1.RunCancelled:
+			failedRuns = append(failedRuns, run)
+		default:
+			activeRuns = append(activeRuns, run)
+		}
+
+		scheduledTime, err := time.Parse(time.RFC3339, run.Annotations[scheduledTimeAnnotation])
+		if err != nil {
+			log.Error(err, "unable to parse schedule time of run", "run", run.Name)
+			continue
+		}
+		if lastScheduleTime == nil || lastScheduleTime.Before(scheduledTime) {
+			lastScheduleTime = &scheduledTime
+		}
+	}
+
+	// The last schedule time only moves forward, as the Runs of earlier schedules may already have been deleted
+	if lastScheduleTime != nil && (cronRun.Status.LastScheduleTime == nil || cronRun.Status.LastScheduleTime.Time.Before(*lastScheduleTime)) {
+		cronRun.Status.LastScheduleTime = &metav1.Time{Time: *lastScheduleTime}
+	}
+	cronRun.Status.Active = nil
+	for i := range activeRuns {
+		runRef, err := ref.GetReference(r.Scheme, &activeRuns[i])
+		if err != nil {
+			log.Error(err, "unable to make reference to active run", "run", activeRuns[i].Name)
+			continue
+		}
+		cronRun.Status.Active = append(cronRun.Status.Active, *runRef)
+	}
+	if err := r.Status().Update(ctx, cronRun); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Keep a bounded history of finished Runs
+	successfulLimit := int32(defaultSuccessfulRunsHistoryLimit)
+	if cronRun.Spec.SuccessfulRunsHistoryLimit != nil {
+		successfulLimit = *cronRun.Spec.SuccessfulRunsHistoryLimit
+	}
+	failedLimit := int32(defaultFailedRunsHistoryLimit)
+	if cronRun.Spec.FailedRunsHistoryLimit != nil {
+		failedLimit = *cronRun.Spec.FailedRunsHistoryLimit
+	}
+	r.deleteOldestRuns(successfulRuns, successfulLimit)
+	r.deleteOldestRuns(failedRuns, failedLimit)
+
+	if cronRun.Spec.Suspend != nil && *cronRun.Spec.Suspend {
+		log.Info("CronRun suspended, skipping")
+		return ctrl.Result{}, nil
+	}
+
+	earliest := cronRun.CreationTimestamp.Time
+	if cronRun.Status.LastScheduleTime != nil {
+		earliest = cronRun.Status.LastScheduleTime.Time
+	}
+	now := time.Now()
+	// Only look for missed runs within the starting deadline
+	if cronRun.Spec.StartingDeadlineSeconds != nil {
+		deadline := now.Add(-time.Second * time.Duration(*cronRun.Spec.StartingDeadlineSeconds))
+		if deadline.After(earliest) {
+			earliest = deadline
+		}
+	}
+	missedRun, nextRun, err := core.MissedSchedule(cronRun.Spec.Schedule, earliest, now)
+	if err != nil {
+		r.Recorder.Event(cronRun, "Warning", terraformv1.InvalidSchedule, err.Error())
+		// The schedule will not fix itself, so wait for the CronRun to change
+		return ctrl.Result{}, nil
+	}
+	scheduledResult := ctrl.Result{RequeueAfter: nextRun.Sub(now)}
+	if missedRun.IsZero() {
+		return scheduledResult, nil
+	}
+
+	if cronRun.Spec.ConcurrencyPolicy == terraformv1.ForbidConcurrent && len(activeRuns) > 0 {
+		log.Info("Concurrency policy blocks concurrent runs, skipping", "active runs", len(activeRuns))
+		return scheduledResult, nil
+	}
+	// Active runs are cancelled rather than deleted, so Terraform releases the state lock and the runs keep their
+	// outcome and logs. The scheduled run is created once they have finished, which requeues the CronRun.
+	if cronRun.Spec.ConcurrencyPolicy == terraformv1.ReplaceConcurrent && len(activeRuns) > 0 {
+		for i := range activeRuns {
+			if activeRuns[i].Spec.Cancel {
+				continue
+			}
+			activeRuns[i].Spec.Cancel = true
+			if err := r.Update(ctx, &activeRuns[i]); ignoreNotFound(err) != nil {
+				return ctrl.Result{}, err
+			}
+			r.Recorder.Event(cronRun, "Normal", "RunCancelled", fmt.Sprintf("Cancelled run/%s to replace it", activeRuns[i].Name))
+		}
+		log.Info("Concurrency policy replaces active runs, waiting for them to be cancelled", "active runs", len(activeRuns))
+		return scheduledResult, nil
+	}
+
+	run, err := r.constructRun(cronRun, missedRun)
+	if err != nil {
+		return scheduledResult, err
+	}
+	if err := r.Create(ctx, run); err != nil && !errors.IsAlreadyExists(err) {
+		return ctrl.Result{}, err
+	}
+	r.Recorder.Event(cronRun, "Normal", "RunCreated", fmt.Sprintf("Created run/%s", run.Name))
+	// Record the schedule right away, in case the Run is deleted before the CronRun is reconciled again
+	cronRun.Status.LastScheduleTime = &metav1.Time{Time: missedRun}
+	if err := r.Status().Update(ctx, cronRun); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return scheduledResult, nil
+}
+
+// SetupWithManager initializes the CronRun controller with the manager
+// Watch runs created by cronrun controller
+func (r *CronRunReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(&terraformv1.Run{}, runOwnerKey, func(rawObj runtime.Object) []string {
+		run := rawObj.(*terraformv1.Run)
+		owner := metav1.GetControllerOf(run)
+		if owner == nil || owner.APIVersion != terraformv1.GroupVersion.String() || owner.Kind != "CronRun" {
+			return nil
+		}
+		return []string{owner.Name}
+	}); err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&terraformv1.CronRun{}).
+		Owns(&terraformv1.Run{}).
+		Complete(r)
+}
+
+// constructRun builds the Run a CronRun creates for the given scheduled time
+func (r *CronRunReconciler) constructRun(cronRun *terraformv1.CronRun, scheduledTime time.Time) (*terraformv1.Run, error) {
+	// A name derived from the scheduled time keeps the Run from being created twice
+	run := &terraformv1.Run{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        fmt.Sprintf("%s-%d", cronRun.Name, scheduledTime.Unix()),
+			Namespace:   cronRun.Namespace,
+			Labels:      make(map[string]string),
+			Annotations: map[string]string{scheduledTimeAnnotation: scheduledTime.Format(time.RFC3339)},
+		},
+		Spec: terraformv1.RunSpec{
+			WorkspaceName: cronRun.Spec.WorkspaceName,
+			Type:          cronRun.Spec.Type,
+			Targets:       cronRun.Spec.Targets,
+			Replace:       cronRun.Spec.Replace,
+		},
+	}
+	if err := r.SetControllerReference(cronRun, run); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// deleteOldestRuns deletes the oldest of the given finished runs until no more than limit are left
+func (r *CronRunReconciler) deleteOldestRuns(runs []terraformv1.Run, limit int32) {
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].CreationTimestamp.Before(&runs[j].CreationTimestamp)
+	})
+	for i := 0; i < len(runs)-int(limit); i++ {
+		if err := r.Delete(context.Background(), &runs[i], client.PropagationPolicy(metav1.DeletePropagationBackground)); ignoreNotFound(err) != nil {
+			r.Log.Error(err, "unable to delete old run", "run", runs[i].Name)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("CronRunController", func() {
+	const timeout = time.Second * 20
+	const interval = time.Second * 1
+
+	var ctx = context.TODO()
+	var namespace string
+	var namespaces int
+
+	// Runs of the CronRun stay active, as their workspace does not exist and no job is started for them
+	newCronRun := func(policy terraformv1.ConcurrencyPolicy) *terraformv1.CronRun {
+		return &terraformv1.CronRun{
+			ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: namespace},
+			Spec: terraformv1.CronRunSpec{
+				Schedule:          "@every 1s",
+				WorkspaceName:     "missing",
+				ConcurrencyPolicy: policy,
+			},
+		}
+	}
+
+	listRuns := func() []terraformv1.Run {
+		runList := &terraformv1.RunList{}
+		Expect(k8sClient.List(ctx, runList, client.InNamespace(namespace))).Should(Succeed())
+		return runList.Items
+	}
+
+	setRunPhase := func(name string, phase terraformv1.ObjectPhase) {
+		Eventually(func() error {
+			run := &terraformv1.Run{}
+			if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, run); err != nil {
+				return err
+			}
+			run.Status.Phase = phase
+			return k8sClient.Status().Update(ctx, run)
+		}, timeout, interval).Should(Succeed())
+	}
+
+	scheduledAt := func(run terraformv1.Run) time.Time {
+		scheduled, err := time.Parse(time.RFC3339, run.Annotations[scheduledTimeAnnotation])
+		Expect(err).NotTo(HaveOccurred())
+		return scheduled
+	}
+
+	// Every test gets its own namespace, as the test environment does not garbage collect the runs of a CronRun
+	BeforeEach(func() {
+		namespaces++
+		namespace = fmt.Sprintf("cronrun-%d", namespaces)
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}}
+		Expect(k8sClient.Create(ctx, ns)).Should(Succeed(), "failed to create namespace")
+	})
+
+	AfterEach(func() {
+		cronRun := &terraformv1.CronRun{ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: namespace}}
+		Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, cronRun))).Should(Succeed(), "failed to DELETE cronrun")
+		for _, run := range listRuns() {
+			run := run
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, &run))).Should(Succeed(), "failed to DELETE run")
+		}
+	})
+
+	Context("Missed schedules", func() {
+		It("Should create a single run for the latest missed schedule", func() {
+			suspend := true
+			cronRun := newCronRun(terraformv1.ForbidConcurrent)
+			cronRun.Spec.Suspend = &suspend
+			Expect(k8sClient.Create(ctx, cronRun)).Should(Succeed(), "failed to create cronrun")
+
+			Consistently(listRuns, 3*time.Second, interval).Should(BeEmpty())
+
+			resumed := time.Now().Truncate(time.Second)
+			Eventually(func() error {
+				if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "nightly"}, cronRun); err != nil {
+					return err
+				}
+				suspend = false
+				return k8sClient.Update(ctx, cronRun)
+			}, timeout, interval).Should(Succeed())
+
+			Eventually(listRuns, timeout, interval).Should(HaveLen(1))
+			run := listRuns()[0]
+			Expect(scheduledAt(run)).Should(BeTemporally(">=", resumed.Add(-time.Second)))
+			Expect(metav1.IsControlledBy(&run, cronRun)).Should(BeTrue())
+			Expect(run.Spec.WorkspaceName).Should(Equal("missing"))
+		})
+	})
+
+	Context("Forbid", func() {
+		It("Should skip schedules while a run is active", func() {
+			Expect(k8sClient.Create(ctx, newCronRun(terraformv1.ForbidConcurrent))).Should(Succeed(), "failed to create cronrun")
+
+			Eventually(listRuns, timeout, interval).Should(HaveLen(1))
+			Consistently(listRuns, 3*time.Second, interval).Should(HaveLen(1))
+			first := listRuns()[0]
+
+			cronRun := &terraformv1.CronRun{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "nightly"}, cronRun)).Should(Succeed())
+			Expect(cronRun.Status.Active).Should(HaveLen(1))
+			Expect(cronRun.Status.Active[0].Name).Should(Equal(first.Name))
+
+			By("Finishing the active run")
+			setRunPhase(first.Name, terraformv1.ObjSucceeded)
+			Eventually(func() []terraformv1.Run {
+				var next []terraformv1.Run
+				for _, run := range listRuns() {
+					if run.Name != first.Name {
+						next = append(next, run)
+					}
+				}
+				return next
+			}, timeout, interval).ShouldNot(BeEmpty())
+		})
+	})
+
+	Context("Replace", func() {
+		It("Should cancel the active run before creating the next one", func() {
+			// Cancelled runs count as failed, so they are kept long enough to be checked
+			failedLimit := int32(10)
+			cronRun := newCronRun(terraformv1.ReplaceConcurrent)
+			cronRun.Spec.FailedRunsHistoryLimit = &failedLimit
+			Expect(k8sClient.Create(ctx, cronRun)).Should(Succeed(), "failed to create cronrun")
+
+			Eventually(listRuns, timeout, interval).ShouldNot(BeEmpty())
+			first := listRuns()[0]
+
+			replaced := &terraformv1.Run{}
+			Eventually(func() terraformv1.ObjectPhase {
+				if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: first.Name}, replaced); err != nil {
+					return ""
+				}
+				return replaced.Status.Phase
+			}, timeout, interval).Should(Equal(terraformv1.RunCancelled))
+			Expect(replaced.Spec.Cancel).Should(BeTrue())
+
+			Eventually(func() bool {
+				for _, run := range listRuns() {
+					if scheduledAt(run).After(scheduledAt(first)) {
+						return true
+					}
+				}
+				return false
+			}, timeout, interval).Should(BeTrue())
+		})
+	})
+
+	Context("No history", func() {
+		It("Should not run a schedule again once its run is deleted", func() {
+			// The run is deleted as soon as it finishes, well before the next schedule
+			limit := int32(0)
+			cronRun := newCronRun(terraformv1.ForbidConcurrent)
+			cronRun.Spec.Schedule = "@every 5s"
+			cronRun.Spec.SuccessfulRunsHistoryLimit = &limit
+			cronRun.Spec.FailedRunsHistoryLimit = &limit
+			Expect(k8sClient.Create(ctx, cronRun)).Should(Succeed(), "failed to create cronrun")
+
+			Eventually(listRuns, timeout, interval).Should(HaveLen(1))
+			first := listRuns()[0]
+			setRunPhase(first.Name, terraformv1.ObjSucceeded)
+			Eventually(listRuns, timeout, interval).Should(BeEmpty())
+
+			Consistently(func() error {
+				run := &terraformv1.Run{}
+				return k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: first.Name}, run)
+			}, 3*time.Second, interval/4).ShouldNot(Succeed())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "nightly"}, cronRun)).Should(Succeed())
+			Expect(cronRun.Status.LastScheduleTime).NotTo(BeNil())
+			Expect(cronRun.Status.LastScheduleTime.Time).Should(BeTemporally(">=", scheduledAt(first)))
+		})
+	})
+
+	Context("History limits", func() {
+		It("Should only keep the configured number of finished runs", func() {
+			suspend := true
+			successfulLimit := int32(1)
+			failedLimit := int32(0)
+			cronRun := newCronRun(terraformv1.AllowConcurrent)
+			cronRun.Spec.Suspend = &suspend
+			cronRun.Spec.SuccessfulRunsHistoryLimit = &successfulLimit
+			cronRun.Spec.FailedRunsHistoryLimit = &failedLimit
+			Expect(k8sClient.Create(ctx, cronRun)).Should(Succeed(), "failed to create cronrun")
+
+			reconciler := &CronRunReconciler{Reconciler: Reconciler{Scheme: scheme.Scheme}}
+			phases := []terraformv1.ObjectPhase{terraformv1.ObjSucceeded, terraformv1.ObjSucceeded, terraformv1.ObjSucceeded, terraformv1.ObjFailed}
+			for i, phase := range phases {
+				run, err := reconciler.constructRun(cronRun, time.Now().Add(-time.Duration(len(phases)-i)*time.Minute))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(k8sClient.Create(ctx, run)).Should(Succeed(), "failed to create run")
+				setRunPhase(run.Name, phase)
+			}
+
+			Eventually(func() []terraformv1.ObjectPhase {
+				var remaining []terraformv1.ObjectPhase
+				for _, run := range listRuns() {
+					remaining = append(remaining, run.Status.Phase)
+				}
+				return remaining
+			}, timeout, interval).Should(Equal([]terraformv1.ObjectPhase{terraformv1.ObjSucceeded}))
+		})
+	})
+})
@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 
 	terraformv1 "github.com/scipian/terraform-controller/api/v1"
@@ -29,8 +30,27 @@ import (
 	"github.com/scipian/terraform-controller/pkg/terraform"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	// runWorkspaceKey indexes Runs by the name of their Workspace
+	runWorkspaceKey = ".spec.workspaceName"
+
+	// cancelPollInterval is how often a cancelled run checks whether the pods of its jobs have terminated
+	cancelPollInterval = 5 * time.Second
+
+	// queuePollInterval is how often a run at the front of the queue checks whether the drift check of its workspace
+	// has finished
+	queuePollInterval = 10 * time.Second
+
+	// rerunPollInterval is how often a rerun checks whether the jobs of its previous attempt have been deleted
+	rerunPollInterval = 5 * time.Second
 )
 
 // RunReconciler reconciles a Run object
@@ -47,7 +67,6 @@ type RunReconciler struct {
 // Reconcile is the reconciler function for Run Custom Resources
 func (r *RunReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	var terraformCmd string
-	destroyTrue := true
 	run := &terraformv1.Run{}
 	workspace := &terraformv1.Workspace{}
 
@@ -62,12 +81,56 @@ func (r *RunReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	}
 	// Update run status for a new run object
 	if run.Status.Phase == "" {
+		core.StartAttempt(run)
 		if err := r.updateStatus(run, terraformv1.ObjPending, terraformv1.PendingJobCreation, false); err != nil {
 			return ctrl.Result{}, err
 		}
 		r.Recorder.Event(run, "Normal", "Scheduled", "Waiting for job creation")
 	}
 
+	// Edited runs and runs asked to rerun start a new attempt with fresh jobs
+	if waiting, err := r.rerun(run); err != nil {
+		return ctrl.Result{}, err
+	} else if waiting {
+		return ctrl.Result{RequeueAfter: rerunPollInterval}, nil
+	}
+
+	// Finished runs are kept until their TTL expires or newer runs push them out of the workspace's history
+	if core.RunFinished(run) {
+		requeueAfter, deleted, err := r.collectRuns(run)
+		if err != nil || deleted {
+			return ctrl.Result{}, err
+		}
+		// Runs that could not retrieve the tfstate keep retrying
+		if run.Status.Phase != terraformv1.ObjIncomplete {
+			return ctrl.Result{RequeueAfter: requeueAfter}, nil
+		}
+	}
+
+	if run.Spec.Cancel {
+		return r.cancelRun(run)
+	}
+
+	// Runs with malformed resource addresses or imports fail without starting a job
+	if reason, err := validateRun(run); err != nil {
+		if run.Status.Reason != reason {
+			if err := r.updateStatus(run, terraformv1.ObjFailed, reason, false); err != nil {
+				return ctrl.Result{}, err
+			}
+			r.Recorder.Event(run, "Warning", string(run.Status.Phase), err.Error())
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Runs against the same workspace start one at a time, in order of creation, and after its drift check
+	if queued, err := r.queueRun(run); err != nil || queued {
+		// Drift check jobs belong to the workspace, so their completion does not requeue the run
+		if err == nil && run.Status.Reason == terraformv1.WaitingForDrift {
+			return ctrl.Result{RequeueAfter: queuePollInterval}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
 	if err := r.Get(ctx, types.NamespacedName{Name: run.Spec.WorkspaceName, Namespace: run.Namespace}, workspace); err != nil {
 		if !errors.IsNotFound(err) {
 			log.Error(err, "unable to GET Workspace")
@@ -75,17 +138,41 @@ func (r *RunReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return ctrl.Result{}, ignoreNotFound(err)
 	}
 
-	if run.Spec.DestroyResource == destroyTrue {
+	switch runType(run) {
+	case terraformv1.RunTypeDestroy:
 		terraformCmd = core.TFDestroy
-	} else {
+	case terraformv1.RunTypeRefreshOnly:
+		terraformCmd = core.TFRefreshOnly
+	case terraformv1.RunTypeImport:
+		terraformCmd = terraform.ImportCommand(run.Spec.Imports)
+	case terraformv1.RunTypeRollback:
+		// The version is looked up before the job starts, as newer versions push older ones out of the history
+		if _, found := core.FindStateVersion(workspace, run.Spec.StateVersion); !found && !core.RunStarted(run) {
+			if run.Status.Reason != terraformv1.InvalidVersion {
+				if err := r.updateStatus(run, terraformv1.ObjFailed, terraformv1.InvalidVersion, false); err != nil {
+					return ctrl.Result{}, err
+				}
+				r.Recorder.Event(run, "Warning", string(run.Status.Phase),
+					fmt.Sprintf("State version %s is not in the history of workspace/%s", run.Spec.StateVersion, workspace.Name))
+			}
+			return ctrl.Result{}, nil
+		}
+		terraformCmd = core.TFStatePush
+	case terraformv1.RunTypeForceUnlock:
+		terraformCmd = terraform.ForceUnlockCommand(run.Spec.LockID)
+	default:
+		if isStaged(run) {
+			return r.reconcileStages(run, workspace)
+		}
 		terraformCmd = core.TFPlan
 	}
 
-	if err := r.startJob(run, terraformCmd, workspace); err != nil {
+	terraformCmd = terraform.ResourceFlags(terraformCmd, run.Spec.Targets, run.Spec.Replace)
+	if err := r.startJob(run, run.Name, terraformCmd, workspace); err != nil {
 		return ctrl.Result{}, err
 	}
 	if !run.Status.JobCompleted {
-		if err := r.checkJobStatus(run); err != nil {
+		if err := r.checkJobStatus(run, run.Name); err != nil {
 			return ctrl.Result{}, err
 		}
 	}
@@ -98,31 +185,436 @@ func (r *RunReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 }
 
 // SetupWithManager initializes the Run controller with the manager
-// Watch job created by run controller
+// Watch job created by run controller, and requeue queued runs whenever a run against their workspace changes
 // TODO PTG: Watch pod created by jobs - Tracked in https://github.com/scipian/terraform-controller/issues/37
 func (r *RunReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(&terraformv1.Run{}, runWorkspaceKey, func(rawObj runtime.Object) []string {
+		run := rawObj.(*terraformv1.Run)
+		return []string{run.Spec.WorkspaceName}
+	}); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&terraformv1.Run{}).
 		Owns(&batchv1.Job{}).
+		Watches(&source.Kind{Type: &terraformv1.Run{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.queuedRuns),
+		}).
 		Complete(r)
 }
 
-func (r *RunReconciler) startJob(run *terraformv1.Run, terraformCmd string, workspace *terraformv1.Workspace) error {
+// rerun starts a new attempt of a run that was edited or asked to rerun, once its current attempt has finished or is
+// waiting for approval, and replaces the jobs and ConfigMaps of the previous attempt. It reports whether the run is
+// waiting for the jobs of the previous attempt to be deleted.
+func (r *RunReconciler) rerun(run *terraformv1.Run) (bool, error) {
+	ctx := context.Background()
+	switch {
+	case run.Status.SpecHash == "":
+		// Runs created before attempts were tracked start tracking from their current spec
+		core.StartAttempt(run)
+		return false, r.Status().Update(ctx, run)
+	case core.RerunRequested(run) && !core.RunStarted(run):
+		// Runs that have not started yet will execute their current spec anyway
+		run.Status.SpecHash = core.RunSpecHash(run.Spec)
+		run.Status.Rerun = run.Annotations[core.RerunAnnotation]
+		return false, r.Status().Update(ctx, run)
+	case core.RerunRequested(run) && (core.RunFinished(run) || run.Status.Phase == terraformv1.RunAwaitingApproval):
+		// The new plan has to be approved again, and a cancelled run is retried
+		if run.Spec.Approved || run.Spec.Cancel {
+			run.Spec.Approved = false
+			run.Spec.Cancel = false
+			if err := r.Update(ctx, run); err != nil {
+				return false, err
+			}
+		}
+		core.StartAttempt(run)
+		if err := r.updateStatus(run, terraformv1.ObjPending, terraformv1.PendingJobDeletion, false); err != nil {
+			return false, err
+		}
+		r.Recorder.Event(run, "Normal", "Rerun", fmt.Sprintf("Starting attempt %d", run.Status.Attempt))
+	}
+	if run.Status.Reason != terraformv1.PendingJobDeletion {
+		return false, nil
+	}
+
+	// Jobs are deleted in the foreground, so they are only gone once their pods are
+	deleting := false
+	for _, jobName := range []string{run.Name, applyJobName(run)} {
+		job := &batchv1.Job{}
+		if err := r.Get(ctx, types.NamespacedName{Name: jobName, Namespace: run.Namespace}, job); err != nil {
+			if !errors.IsNotFound(err) {
+				return false, err
+			}
+		} else {
+			deleting = true
+			if job.DeletionTimestamp == nil {
+				if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationForeground)); ignoreNotFound(err) != nil {
+					return false, err
+				}
+			}
+		}
+		configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: jobName, Namespace: run.Namespace}}
+		if err := r.Delete(ctx, configMap); ignoreNotFound(err) != nil {
+			return false, err
+		}
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: terraform.VariablesSecretName(jobName), Namespace: run.Namespace}}
+		if err := r.Delete(ctx, secret); ignoreNotFound(err) != nil {
+			return false, err
+		}
+		rollback := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: terraform.RollbackSecretName(jobName), Namespace: run.Namespace}}
+		if err := r.Delete(ctx, rollback); ignoreNotFound(err) != nil {
+			return false, err
+		}
+		credentials := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: terraform.CredentialsSecretName(jobName), Namespace: run.Namespace}}
+		if err := r.Delete(ctx, credentials); ignoreNotFound(err) != nil {
+			return false, err
+		}
+	}
+	if deleting {
+		return true, nil
+	}
+	return false, r.updateStatus(run, terraformv1.ObjPending, terraformv1.PendingJobCreation, false)
+}
+
+// cancelRun deletes the jobs of a cancelled run, which interrupts Terraform, and waits for their pods to terminate
+func (r *RunReconciler) cancelRun(run *terraformv1.Run) (ctrl.Result, error) {
+	terminating := false
+	storedLogs := len(run.Status.Logs)
+	for _, jobName := range []string{run.Name, applyJobName(run)} {
+		r.persistLogs(run, jobName)
+		job := &batchv1.Job{}
+		if err := r.Get(context.Background(), types.NamespacedName{Name: jobName, Namespace: run.Namespace}, job); err != nil {
+			if !errors.IsNotFound(err) {
+				return ctrl.Result{}, err
+			}
+		} else if job.DeletionTimestamp == nil {
+			if err := r.Delete(context.Background(), job, client.PropagationPolicy(metav1.DeletePropagationBackground)); ignoreNotFound(err) != nil {
+				return ctrl.Result{}, err
+			}
+		}
+
+		podList := &corev1.PodList{}
+		podLabel := map[string]string{"job-name": jobName}
+		if err := r.List(context.Background(), podList, client.InNamespace(run.Namespace), client.MatchingLabels(podLabel)); err != nil {
+			return ctrl.Result{}, err
+		}
+		terminating = terminating || len(podList.Items) > 0
+	}
+
+	// Terraform holds the state lock until its pod has terminated
+	if terminating {
+		cancelling := run.Status.Phase == terraformv1.RunCancelling
+		if !cancelling || len(run.Status.Logs) != storedLogs {
+			if err := r.updateStatus(run, terraformv1.RunCancelling, terraformv1.CancelRequested, false); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		if !cancelling {
+			r.Recorder.Event(run, "Normal", string(run.Status.Phase), "Interrupting terraform")
+		}
+		return ctrl.Result{RequeueAfter: cancelPollInterval}, nil
+	}
+
+	if err := r.updateStatus(run, terraformv1.RunCancelled, terraformv1.CancelCompleted, false); err != nil {
+		return ctrl.Result{}, err
+	}
+	r.Recorder.Event(run, "Normal", string(run.Status.Phase), "Run cancelled")
+	return ctrl.Result{}, nil
+}
+
+// queueRun holds a run in the Queued phase until the runs ahead of it against the same workspace, and the drift check
+// of the workspace in progress, if any, have finished. It reports whether the run is still queued.
+func (r *RunReconciler) queueRun(run *terraformv1.Run) (bool, error) {
+	runList := &terraformv1.RunList{}
+	if err := r.List(context.Background(), runList, client.InNamespace(run.Namespace), client.MatchingField(runWorkspaceKey, run.Spec.WorkspaceName)); err != nil {
+		return false, err
+	}
+
+	reason := terraformv1.WaitingForRun
+	message := ""
+	position := core.QueuePosition(run, runList.Items)
+	if position == 0 {
+		checking, err := r.driftCheckRunning(run)
+		if err != nil {
+			return false, err
+		}
+		if !checking {
+			if run.Status.Phase == terraformv1.RunQueued {
+				run.Status.QueuePosition = 0
+				if err := r.updateStatus(run, terraformv1.ObjPending, terraformv1.PendingJobCreation, false); err != nil {
+					return false, err
+				}
+				r.Recorder.Event(run, "Normal", "Dequeued", "Waiting for job creation")
+			}
+			return false, nil
+		}
+		reason = terraformv1.WaitingForDrift
+		message = fmt.Sprintf("Waiting for the drift check of workspace/%s to finish", run.Spec.WorkspaceName)
+	} else {
+		message = fmt.Sprintf("Waiting for %d run(s) against workspace/%s to finish", position, run.Spec.WorkspaceName)
+	}
+
+	if run.Status.Phase != terraformv1.RunQueued || run.Status.QueuePosition != position || run.Status.Reason != reason {
+		run.Status.QueuePosition = position
+		if err := r.updateStatus(run, terraformv1.RunQueued, reason, false); err != nil {
+			return true, err
+		}
+		r.Recorder.Event(run, "Normal", string(run.Status.Phase), message)
+	}
+	return true, nil
+}
+
+// driftCheckRunning reports whether the drift check job of the workspace of a run is running
+func (r *RunReconciler) driftCheckRunning(run *terraformv1.Run) (bool, error) {
+	job := &batchv1.Job{}
+	if err := r.Get(context.Background(), types.NamespacedName{Namespace: run.Namespace, Name: driftJobName(run.Spec.WorkspaceName)}, job); err != nil {
+		return false, ignoreNotFound(err)
+	}
+	return job.Status.Succeeded == 0 && job.Status.Failed == 0, nil
+}
+
+// collectRuns deletes a finished run once it expires, along with the finished runs against its workspace that exceed
+// the history limit. It returns how long until the run expires, and whether the run was deleted.
+func (r *RunReconciler) collectRuns(run *terraformv1.Run) (time.Duration, bool, error) {
+	policy, err := core.RetentionPolicyFromEnv()
+	if err != nil {
+		return 0, false, err
+	}
+
+	if policy.HistoryLimit != nil {
+		runList := &terraformv1.RunList{}
+		if err := r.List(context.Background(), runList, client.InNamespace(run.Namespace), client.MatchingField(runWorkspaceKey, run.Spec.WorkspaceName)); err != nil {
+			return 0, false, err
+		}
+		deleted := false
+		for _, excess := range policy.ExcessRuns(runList.Items) {
+			if err := r.deleteRun(&excess, fmt.Sprintf("Deleted run/%s beyond the run history limit", excess.Name)); err != nil {
+				return 0, false, err
+			}
+			deleted = deleted || excess.Name == run.Name
+		}
+		if deleted {
+			return 0, true, nil
+		}
+	}
+
+	expiry, expires := policy.RunExpiry(run)
+	if !expires {
+		return 0, false, nil
+	}
+	if remaining := time.Until(expiry); remaining > 0 {
+		return remaining, false, nil
+	}
+	if err := r.deleteRun(run, fmt.Sprintf("Deleted run/%s after its TTL expired", run.Name)); err != nil {
+		return 0, false, err
+	}
+	return 0, true, nil
+}
+
+// deleteRun deletes a run. The jobs, pods, ConfigMaps and volume claims it owns are garbage collected with it.
+func (r *RunReconciler) deleteRun(run *terraformv1.Run, message string) error {
+	workspace := &terraformv1.Workspace{}
+	if err := r.Delete(context.Background(), run, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil {
+		return ignoreNotFound(err)
+	}
+	log.Println(message)
+	// Record the deletion on the workspace, since events about the run go away with it
+	if err := r.Get(context.Background(), types.NamespacedName{Name: run.Spec.WorkspaceName, Namespace: run.Namespace}, workspace); err == nil {
+		r.Recorder.Event(workspace, "Normal", "RunDeleted", message)
+	}
+	return nil
+}
+
+// queuedRuns maps a run to the runs queued behind it against the same workspace
+func (r *RunReconciler) queuedRuns(obj handler.MapObject) []reconcile.Request {
+	run, ok := obj.Object.(*terraformv1.Run)
+	if !ok {
+		return nil
+	}
+	runList := &terraformv1.RunList{}
+	if err := r.List(context.Background(), runList, client.InNamespace(run.Namespace), client.MatchingField(runWorkspaceKey, run.Spec.WorkspaceName)); err != nil {
+		r.Log.Error(err, "unable to list runs", "workspace", run.Spec.WorkspaceName)
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, queued := range runList.Items {
+		if queued.Name == run.Name || core.RunStarted(&queued) || core.RunFinished(&queued) {
+			continue
+		}
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: queued.Name, Namespace: queued.Namespace},
+		})
+	}
+	return requests
+}
+
+// reconcileStages drives the separate plan and apply jobs of plan-only and approval-gated runs
+func (r *RunReconciler) reconcileStages(run *terraformv1.Run, workspace *terraformv1.Workspace) (ctrl.Result, error) {
+	if !run.Status.PlanCompleted {
+		terraformCmd := terraform.ResourceFlags(core.TFPlanOnly, run.Spec.Targets, run.Spec.Replace)
+		if err := r.startJob(run, run.Name, terraformCmd, workspace); err != nil {
+			return ctrl.Result{}, err
+		}
+		if !run.Status.JobCompleted {
+			if err := r.checkJobStatus(run, run.Name); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		if err := r.completePlan(run); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Nothing left to do for plan-only runs, and approval-gated runs wait until they are approved
+	if run.Spec.Mode == terraformv1.RunModePlan {
+		return ctrl.Result{}, nil
+	}
+	if !run.Spec.Approved {
+		return r.awaitApproval(run)
+	}
+
+	if err := r.startJob(run, applyJobName(run), core.TFApplyPlan, workspace); err != nil {
+		return ctrl.Result{}, err
+	}
+	if !run.Status.JobCompleted {
+		if err := r.checkJobStatus(run, applyJobName(run)); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	if err := r.retrieveState(run, workspace); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// awaitApproval cancels a run that has waited for approval past its deadline, so it no longer holds the queue of its
+// workspace, and otherwise requeues the run for its deadline
+func (r *RunReconciler) awaitApproval(run *terraformv1.Run) (ctrl.Result, error) {
+	if run.Status.Phase != terraformv1.RunAwaitingApproval {
+		return ctrl.Result{}, nil
+	}
+	timeout, err := core.ApprovalTimeoutFromEnv()
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	deadline, expires := core.ApprovalDeadline(run, timeout)
+	if !expires {
+		return ctrl.Result{}, nil
+	}
+	if wait := time.Until(deadline); wait > 0 {
+		return ctrl.Result{RequeueAfter: wait}, nil
+	}
+	if err := r.updateStatus(run, terraformv1.RunCancelled, terraformv1.ApprovalExpired, false); err != nil {
+		return ctrl.Result{}, err
+	}
+	r.Recorder.Event(run, "Warning", string(run.Status.Phase), fmt.Sprintf("Not approved by %s", deadline.Format(time.RFC3339)))
+	return ctrl.Result{}, nil
+}
+
+// applyJobName returns the name of the job applying the saved plan of a staged run
+func applyJobName(run *terraformv1.Run) string {
+	return fmt.Sprintf("%s-apply", run.Name)
+}
+
+// runType returns the type of a run, honouring the deprecated destroyResource field
+func runType(run *terraformv1.Run) terraformv1.RunType {
+	if run.Spec.DestroyResource {
+		return terraformv1.RunTypeDestroy
+	}
+	if run.Spec.Type == "" {
+		return terraformv1.RunTypeApply
+	}
+	return run.Spec.Type
+}
+
+// isStaged reports whether a run plans and applies in separate jobs
+func isStaged(run *terraformv1.Run) bool {
+	return runType(run) == terraformv1.RunTypeApply &&
+		(run.Spec.Mode == terraformv1.RunModePlan || run.Spec.Mode == terraformv1.RunModeApproval)
+}
+
+// validateRun checks the addresses and imports of a run, returning the status reason to fail it with
+func validateRun(run *terraformv1.Run) (string, error) {
+	for _, address := range run.Spec.Targets {
+		if err := terraform.ValidateTarget(address); err != nil {
+			return terraformv1.InvalidAddress, err
+		}
+	}
+	for _, address := range run.Spec.Replace {
+		if err := terraform.ValidateReplace(address); err != nil {
+			return terraformv1.InvalidAddress, err
+		}
+	}
+	if runType(run) == terraformv1.RunTypeRollback && run.Spec.StateVersion == "" {
+		return terraformv1.InvalidVersion, fmt.Errorf("rollback runs need the stateVersion to restore")
+	}
+	if runType(run) == terraformv1.RunTypeForceUnlock && run.Spec.LockID == "" {
+		return terraformv1.InvalidLockID, fmt.Errorf("force-unlock runs need the lockID of the lock to release")
+	}
+	if runType(run) != terraformv1.RunTypeImport {
+		return "", nil
+	}
+	if len(run.Spec.Imports) == 0 {
+		return terraformv1.InvalidImport, fmt.Errorf("import runs need at least one resource to import")
+	}
+	for _, resource := range run.Spec.Imports {
+		if err := terraform.ValidateReplace(resource.Address); err != nil {
+			return terraformv1.InvalidImport, fmt.Errorf("invalid import address %q", resource.Address)
+		}
+		if resource.ID == "" {
+			return terraformv1.InvalidImport, fmt.Errorf("missing import ID for %q", resource.Address)
+		}
+	}
+	return "", nil
+}
+
+// completePlan moves a run whose plan job has succeeded to Succeeded (plan mode) or AwaitingApproval
+func (r *RunReconciler) completePlan(run *terraformv1.Run) error {
+	if !run.Status.JobCompleted {
+		return nil
+	}
+	run.Status.PlanCompleted = true
+	if run.Spec.Mode == terraformv1.RunModePlan {
+		if err := r.updateStatus(run, terraformv1.ObjSucceeded, terraformv1.PlanCompleted, true); err != nil {
+			return err
+		}
+		r.Recorder.Event(run, "Normal", string(run.Status.Phase), "Plan completed successfully")
+		return nil
+	}
+	// Reset JobCompleted so the apply job is tracked once the run is approved
+	now := metav1.Now()
+	run.Status.AwaitingApprovalTime = &now
+	if err := r.updateStatus(run, terraformv1.RunAwaitingApproval, terraformv1.PlanCompleted, false); err != nil {
+		return err
+	}
+	r.Recorder.Event(run, "Normal", string(run.Status.Phase), "Plan completed, waiting for approval")
+	return nil
+}
+
+func (r *RunReconciler) startJob(run *terraformv1.Run, jobName string, terraformCmd string, workspace *terraformv1.Workspace) error {
 	foundRunJob := &batchv1.Job{}
 	foundConfigMap := &corev1.ConfigMap{}
-	secret := &corev1.Secret{}
-	secretKey := types.NamespacedName{Namespace: core.ScipianNamespace, Name: core.ScipianIAMSecretName}
-	runKey := types.NamespacedName{Namespace: run.Namespace, Name: run.Name}
+	runKey := types.NamespacedName{Namespace: run.Namespace, Name: jobName}
 
-	if err := r.GetSecret(secretKey, secret); err != nil {
+	iamAccessKey, iamSecretKey, err := r.IAMCredentials()
+	if err != nil {
 		return err
 	}
-	iamAccessKey := string(secret.Data[core.AccessKey])
-	iamSecretKey := string(secret.Data[core.SecretKey])
 
-	configMap := terraform.CreateConfigMap(runKey, iamAccessKey, iamSecretKey, workspace)
+	backend, err := core.ResolveBackend(workspace)
+	if err != nil {
+		return err
+	}
+	configMap, err := terraform.CreateConfigMap(runKey, backend, workspace)
+	if err != nil {
+		return err
+	}
 	// Pull image if not present for runs
 	runJob := terraform.CreateJob(runKey, terraformCmd, workspace, false)
+	terraform.AddBackend(runJob, backend)
 
 	// Set Run as owner of configmap and job object
 	if err := r.SetControllerReference(run, configMap); err != nil {
@@ -133,6 +625,46 @@ func (r *RunReconciler) startJob(run *terraformv1.Run, terraformCmd string, work
 		return err
 	}
 
+	if err := r.AddVariables(run, workspace, runJob, configMap); err != nil {
+		return err
+	}
+
+	if err := r.AddCredentials(run, runJob, backend, iamAccessKey, iamSecretKey); err != nil {
+		return err
+	}
+
+	if err := r.AddAssumeRole(workspace, runJob, configMap); err != nil {
+		return err
+	}
+
+	// Cancelling the run or running out of time interrupts Terraform, so it can release the state lock
+	terraform.AddGracefulShutdown(runJob)
+	if run.Spec.Timeout != nil {
+		terraform.AddTimeout(runJob, run.Spec.Timeout.Duration)
+	}
+
+	// Plan and apply jobs of staged runs share the saved plan through a volume owned by the run
+	if isStaged(run) {
+		foundClaim := &corev1.PersistentVolumeClaim{}
+		claimKey := types.NamespacedName{Namespace: run.Namespace, Name: terraform.PlanVolumeClaimName(run.Name)}
+		claim := terraform.CreatePlanVolumeClaim(types.NamespacedName{Namespace: run.Namespace, Name: run.Name})
+		if err := r.SetControllerReference(run, claim); err != nil {
+			return err
+		}
+		if err := r.CreateObject(claimKey, claim, foundClaim); err != nil {
+			return err
+		}
+		terraform.AddPlanVolume(runJob, claimKey.Name)
+	}
+
+	// Rollback jobs push the chosen version of the state from a Secret owned by the run
+	if runType(run) == terraformv1.RunTypeRollback {
+		if err := r.createRollbackSecret(run, workspace); err != nil {
+			return err
+		}
+		terraform.AddRollbackState(runJob, terraform.RollbackSecretName(run.Name))
+	}
+
 	// Create ConfigMap and Job
 	if err := r.CreateObject(runKey, configMap, foundConfigMap); err != nil {
 		return err
@@ -145,31 +677,54 @@ func (r *RunReconciler) startJob(run *terraformv1.Run, terraformCmd string, work
 	return nil
 }
 
-func (r *RunReconciler) retrieveState(run *terraformv1.Run, workspace *terraformv1.Workspace) error {
-	secret := &corev1.Secret{}
-	secretKey := types.NamespacedName{Namespace: core.ScipianNamespace, Name: core.ScipianIAMSecretName}
-	if err := r.GetSecret(secretKey, secret); err != nil {
+// createRollbackSecret creates the Secret holding the version of the state a rollback run pushes, unless it exists
+func (r *RunReconciler) createRollbackSecret(run *terraformv1.Run, workspace *terraformv1.Workspace) error {
+	secretKey := types.NamespacedName{Namespace: run.Namespace, Name: terraform.RollbackSecretName(run.Name)}
+	if err := r.Get(context.TODO(), secretKey, &corev1.Secret{}); err == nil || !errors.IsNotFound(err) {
+		return err
+	}
+	tfState, err := r.ReadStateVersion(workspace, run.Spec.StateVersion)
+	if err != nil {
+		return fmt.Errorf("Error reading state version %s - %s", run.Spec.StateVersion, err)
+	}
+	secret, err := terraform.CreateRollbackSecret(types.NamespacedName{Namespace: run.Namespace, Name: run.Name}, tfState)
+	if err != nil {
 		return err
 	}
-	iamAccessKey := string(secret.Data[core.AccessKey])
-	iamSecretKey := string(secret.Data[core.SecretKey])
+	if err := r.SetControllerReference(run, secret); err != nil {
+		return err
+	}
+	return r.Create(context.TODO(), secret)
+}
 
+func (r *RunReconciler) retrieveState(run *terraformv1.Run, workspace *terraformv1.Workspace) error {
 	if err := r.Get(context.TODO(), types.NamespacedName{Name: run.Name, Namespace: run.Namespace}, run); err != nil {
 		return err
 	}
 	// Retrieve tfstate only if the job completed successfully
 	if run.Status.JobCompleted {
 		log.Printf("Retrieving tfstate")
-		state, err := core.RetrieveState(workspace, iamAccessKey, iamSecretKey)
+		state, err := r.ReadState(workspace)
 		if err != nil {
 			_ = r.updateStatus(run, terraformv1.ObjIncomplete, terraformv1.ErrRetriveTfstate, true)
 			r.Recorder.Event(run, "Warning", string(run.Status.Phase), "Error retrieving tfstate")
 			return fmt.Errorf("Error retrieving tfstate - %s", err)
 		}
-		workspace.Spec.TfState = state
-		if err := r.Update(context.Background(), workspace); err != nil {
+		if err := r.SaveState(workspace, state); err != nil {
+			_ = r.updateStatus(run, terraformv1.ObjIncomplete, terraformv1.ErrStoreTfstate, true)
+			r.Recorder.Event(run, "Warning", string(run.Status.Phase), "Error storing tfstate")
+			return fmt.Errorf("Error storing tfstate - %s", err)
+		}
+		// The job acquired and released the state lock
+		workspace.Status.StateLock = nil
+		if err := r.Status().Update(context.Background(), workspace); err != nil {
 			return err
 		}
+		if err := r.ExportOutputs(workspace, state); err != nil {
+			_ = r.updateStatus(run, terraformv1.ObjIncomplete, terraformv1.ErrExportOutputs, true)
+			r.Recorder.Event(run, "Warning", string(run.Status.Phase), "Error exporting outputs")
+			return fmt.Errorf("Error exporting outputs - %s", err)
+		}
 		if err := r.updateStatus(run, terraformv1.ObjSucceeded, terraformv1.RunSucceeded, true); err != nil {
 			return err
 		}
@@ -181,6 +736,13 @@ func (r *RunReconciler) retrieveState(run *terraformv1.Run, workspace *terraform
 
 // updateStatus updates run status subresource
 func (r *RunReconciler) updateStatus(run *terraformv1.Run, phase terraformv1.ObjectPhase, reason string, jobCompleted bool) error {
+	if phase != run.Status.Phase {
+		switch phase {
+		case terraformv1.ObjSucceeded, terraformv1.ObjFailed, terraformv1.ObjIncomplete, terraformv1.RunCancelled:
+			now := metav1.Now()
+			run.Status.CompletionTime = &now
+		}
+	}
 	run.Status.Phase = phase
 	run.Status.Reason = reason
 	run.Status.JobCompleted = jobCompleted
@@ -191,16 +753,16 @@ func (r *RunReconciler) updateStatus(run *terraformv1.Run, phase terraformv1.Obj
 }
 
 // checkJobStatus checks the status of the job created by run and reconciles run accordingly
-func (r *RunReconciler) checkJobStatus(run *terraformv1.Run) error {
+func (r *RunReconciler) checkJobStatus(run *terraformv1.Run, jobName string) error {
 	var runPhase terraformv1.ObjectPhase
 	var succeededJobs int32 = 1
 	var failedJobs int32 = 1
 	var activePods int32 = 1
-	destroyResource := run.Spec.DestroyResource
+	destroyResource := runType(run) == terraformv1.RunTypeDestroy
 	foundJob := &batchv1.Job{}
 	podList := &corev1.PodList{}
-	podLabel := map[string]string{"job-name": run.Name}
-	if err := r.Get(context.TODO(), types.NamespacedName{Name: run.Name, Namespace: run.Namespace}, foundJob); err != nil {
+	podLabel := map[string]string{"job-name": jobName}
+	if err := r.Get(context.TODO(), types.NamespacedName{Name: jobName, Namespace: run.Namespace}, foundJob); err != nil {
 		return ignoreNotFound(err)
 	}
 	if destroyResource {
@@ -211,6 +773,13 @@ func (r *RunReconciler) checkJobStatus(run *terraformv1.Run) error {
 	switch {
 	case foundJob.Status.Succeeded == succeededJobs:
 		log.Println("Job Succeeded")
+		r.persistLogs(run, jobName)
+		// The jobs of apply runs named after the run print their plan
+		if runType(run) == terraformv1.RunTypeApply && jobName == run.Name {
+			if err := r.recordPlanSummary(run, jobName); err != nil {
+				log.Printf("Unable to summarize plan of job/%s: %v", jobName, err)
+			}
+		}
 		if err := r.updateStatus(run, runPhase, terraformv1.JobCompleted, true); err != nil {
 			return err
 		}
@@ -218,6 +787,22 @@ func (r *RunReconciler) checkJobStatus(run *terraformv1.Run) error {
 		return nil
 	case foundJob.Status.Failed == failedJobs:
 		log.Println("Job Failed")
+		r.persistLogs(run, jobName)
+		if terraform.JobTimedOut(foundJob, time.Now()) {
+			if err := r.updateStatus(run, terraformv1.ObjFailed, terraformv1.TimedOut, false); err != nil {
+				return err
+			}
+			r.Recorder.Event(run, "Warning", string(run.Status.Phase), "Job timed out")
+			return fmt.Errorf("Job timed out")
+		}
+		// A job that died while holding the state lock fails every later job until the lock is released
+		if lock, locked := r.recordStateLock(run, jobName); locked {
+			if err := r.updateStatus(run, terraformv1.ObjFailed, terraformv1.StateLocked, false); err != nil {
+				return err
+			}
+			r.Recorder.Event(run, "Warning", string(run.Status.Phase), lockMessage(lock))
+			return fmt.Errorf("State locked")
+		}
 		if err := r.updateStatus(run, terraformv1.ObjFailed, terraformv1.JobFailed, false); err != nil {
 			return err
 		}
@@ -238,7 +823,7 @@ func (r *RunReconciler) checkJobStatus(run *terraformv1.Run) error {
 			if err := r.Update(context.Background(), run); err != nil {
 				return err
 			}
-			if err := r.checkPodStatus(&pod, run); err != nil {
+			if err := r.checkPodStatus(&pod, run, foundJob); err != nil {
 				return err
 			}
 		}
@@ -252,19 +837,142 @@ func (r *RunReconciler) checkJobStatus(run *terraformv1.Run) error {
 	return nil
 }
 
+// recordStateLock records the lock held on the state of the workspace of a run after a job of the run failed, and
+// reports whether the job failed to acquire the lock
+func (r *RunReconciler) recordStateLock(run *terraformv1.Run, jobName string) (*terraformv1.StateLock, bool) {
+	workspace := &terraformv1.Workspace{}
+	if err := r.Get(context.Background(), types.NamespacedName{Name: run.Spec.WorkspaceName, Namespace: run.Namespace}, workspace); err != nil {
+		log.Printf("Unable to get workspace/%s to record state lock: %v", run.Spec.WorkspaceName, err)
+		return nil, false
+	}
+	locked := r.RecordStateLock(workspace, jobName)
+	if err := r.Status().Update(context.Background(), workspace); err != nil {
+		log.Printf("Unable to record state lock of workspace/%s: %v", workspace.Name, err)
+	}
+	return workspace.Status.StateLock, locked
+}
+
+// recordPlanSummary summarizes the plan printed by the succeeded pod of a job into the run status
+func (r *RunReconciler) recordPlanSummary(run *terraformv1.Run, jobName string) error {
+	podList := &corev1.PodList{}
+	podLabel := map[string]string{"job-name": jobName}
+	if err := r.List(context.Background(), podList, client.InNamespace(run.Namespace), client.MatchingLabels(podLabel)); err != nil {
+		return err
+	}
+	for _, pod := range podList.Items {
+		if pod.Status.Phase != corev1.PodSucceeded {
+			continue
+		}
+		logs, err := r.GetPodLogs(types.NamespacedName{Name: pod.Name, Namespace: pod.Namespace})
+		if err != nil {
+			return err
+		}
+		planJSON, err := terraform.PlanFromLogs(logs)
+		if err != nil {
+			return err
+		}
+		summary, err := terraform.SummarizePlan(planJSON)
+		if err != nil {
+			return err
+		}
+		run.Status.PlanSummary = summary
+		return nil
+	}
+	return fmt.Errorf("no succeeded pod found")
+}
+
+// persistLogs stores the logs of the finished pods of a job in the configured log sink, and records where in the run
+// status. The logs are only kept on a best effort basis, so failures do not fail the run.
+func (r *RunReconciler) persistLogs(run *terraformv1.Run, jobName string) {
+	podList := &corev1.PodList{}
+	podLabel := map[string]string{"job-name": jobName}
+	if err := r.List(context.Background(), podList, client.InNamespace(run.Namespace), client.MatchingLabels(podLabel)); err != nil {
+		log.Printf("Unable to list pods of job/%s: %v", jobName, err)
+		return
+	}
+
+	var sink core.LogSink
+	for _, pod := range podList.Items {
+		if !podFinished(&pod) {
+			continue
+		}
+		stored := false
+		for _, ref := range run.Status.Logs {
+			stored = stored || ref.Pod == pod.Name
+		}
+		if stored {
+			continue
+		}
+
+		if sink == nil {
+			accessKey, secretKey, err := r.IAMCredentials()
+			if err != nil {
+				log.Printf("Unable to get credentials to store logs: %v", err)
+				return
+			}
+			sink, err = core.LogSinkFromEnv(r.Client, r.Scheme, accessKey, secretKey)
+			if err != nil || sink == nil {
+				if err != nil {
+					log.Printf("Unable to store logs: %v", err)
+				}
+				return
+			}
+		}
+
+		logs, err := r.GetPodLogs(types.NamespacedName{Name: pod.Name, Namespace: pod.Namespace})
+		if err != nil {
+			log.Printf("Unable to get logs of pod/%s: %v", pod.Name, err)
+			continue
+		}
+		ref, err := sink.Store(run, pod.Name, terraform.StripPlans(logs))
+		if err != nil {
+			log.Printf("Unable to store logs of pod/%s: %v", pod.Name, err)
+			continue
+		}
+		run.Status.Logs = append(run.Status.Logs, ref)
+	}
+}
+
+// podFinished reports whether all containers of a pod have terminated, which happens before an interrupted pod is
+// deleted
+func podFinished(pod *corev1.Pod) bool {
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return true
+	}
+	if len(pod.Status.ContainerStatuses) == 0 {
+		return false
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Terminated == nil {
+			return false
+		}
+	}
+	return true
+}
+
 // checkPodStatus checks the status of pod created by job and updates run status accordingly
-func (r *RunReconciler) checkPodStatus(pod *corev1.Pod, run *terraformv1.Run) error {
+func (r *RunReconciler) checkPodStatus(pod *corev1.Pod, run *terraformv1.Run, job *batchv1.Job) error {
 	var runPhase terraformv1.ObjectPhase
 	podKey := types.NamespacedName{Name: pod.Name, Namespace: pod.Namespace}
-	if run.Spec.DestroyResource {
+	if runType(run) == terraformv1.RunTypeDestroy {
 		runPhase = terraformv1.RunDestroying
 	} else {
 		runPhase = terraformv1.ObjRunning
 	}
 	for {
+		// Stop waiting once the run is cancelled, so the cancellation can be reconciled
+		if err := r.Get(context.Background(), types.NamespacedName{Name: run.Name, Namespace: run.Namespace}, run); err != nil {
+			return err
+		}
+		if run.Spec.Cancel {
+			return nil
+		}
 		if err := r.Get(context.Background(), podKey, pod); err != nil {
 			return err
 		}
+		if revision := terraform.SourceRevision(pod); revision != "" {
+			run.Status.SourceRevision = revision
+		}
 		switch pod.Status.Phase {
 		case corev1.PodPending:
 			if err := r.updateStatus(run, runPhase, terraformv1.PodPending, false); err != nil {
@@ -272,12 +980,18 @@ func (r *RunReconciler) checkPodStatus(pod *corev1.Pod, run *terraformv1.Run) er
 			}
 			break
 		case corev1.PodSucceeded:
+			r.persistLogs(run, job.Name)
 			if err := r.updateStatus(run, runPhase, terraformv1.PodSucceeded, false); err != nil {
 				return err
 			}
 			return nil
 		case corev1.PodFailed:
-			if err := r.updateStatus(run, terraformv1.ObjFailed, terraformv1.PodFailed, false); err != nil {
+			r.persistLogs(run, job.Name)
+			reason := terraformv1.PodFailed
+			if terraform.JobTimedOut(job, time.Now()) {
+				reason = terraformv1.TimedOut
+			}
+			if err := r.updateStatus(run, terraformv1.ObjFailed, reason, false); err != nil {
 				return err
 			}
 			return nil
@@ -20,9 +20,12 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/robfig/cron/v3"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 
 	terraformv1 "github.com/scipian/terraform-controller/api/v1"
@@ -34,6 +37,14 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+const (
+	// driftCheckPollInterval is how often a running drift check is checked for completion
+	driftCheckPollInterval = 30 * time.Second
+
+	// autoApplyPollInterval is how often a running autoApply Run is checked for completion
+	autoApplyPollInterval = 30 * time.Second
+)
+
 // WorkspaceReconciler reconciles a Workspace object
 type WorkspaceReconciler struct {
 	Reconciler
@@ -41,6 +52,7 @@ type WorkspaceReconciler struct {
 
 // +kubebuilder:rbac:groups=terraform.scipian.io,resources=workspaces,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=terraform.scipian.io,resources=workspaces/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=terraform.scipian.io,resources=runs,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=batch,resources=jobs/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=core,resources=pods/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups="",resources=events,verbs=get;list;watch;create;update;patch;delete
@@ -72,6 +84,9 @@ func (r *WorkspaceReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 				return ctrl.Result{}, err
 			}
 		}
+		if err := r.MigrateState(workspace); err != nil {
+			return ctrl.Result{}, err
+		}
 		if err := r.startJob(workspace.Name, core.TFWorkspaceNew, workspace); err != nil {
 			return ctrl.Result{}, err
 		}
@@ -83,6 +98,14 @@ func (r *WorkspaceReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		if err := r.retrieveState(workspace); err != nil {
 			return ctrl.Result{}, err
 		}
+		if workspace.Spec.AutoApply && workspace.Status.Phase == terraformv1.ObjSucceeded {
+			if result, applying, err := r.autoApply(workspace); err != nil || applying {
+				return result, err
+			}
+		}
+		if workspace.Spec.DriftDetection != nil && workspace.Status.Phase == terraformv1.ObjSucceeded {
+			return r.detectDrift(workspace)
+		}
 	} else {
 		log.Info("Deleting the external dependencies")
 		jobName := fmt.Sprintf("%s-delete", workspace.Name)
@@ -110,32 +133,38 @@ func (r *WorkspaceReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 }
 
 // SetupWithManager initializes the Workspace controller with the manager
-// Watch job created by workspace controller
+// Watch jobs and runs created by workspace controller
 // TODO PTG: Watch pod created by jobs - Tracked in https://github.com/scipian/terraform-controller/issues/37
 func (r *WorkspaceReconciler) SetupWithManager(mgr ctrl.Manager) error {
 
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&terraformv1.Workspace{}).
 		Owns(&batchv1.Job{}).
+		Owns(&terraformv1.Run{}).
 		Complete(r)
 }
 
 func (r *WorkspaceReconciler) startJob(jobName string, terraformCmd string, workspace *terraformv1.Workspace) error {
 	foundWorkspaceJob := &batchv1.Job{}
 	foundConfigMap := &corev1.ConfigMap{}
-	secret := &corev1.Secret{}
-	secretKey := types.NamespacedName{Namespace: core.ScipianNamespace, Name: core.ScipianIAMSecretName}
 	workspaceKey := types.NamespacedName{Namespace: workspace.Namespace, Name: jobName}
 
-	if err := r.GetSecret(secretKey, secret); err != nil {
+	iamAccessKey, iamSecretKey, err := r.IAMCredentials()
+	if err != nil {
 		return err
 	}
-	iamAccessKey := string(secret.Data[core.AccessKey])
-	iamSecretKey := string(secret.Data[core.SecretKey])
 
-	configMap := terraform.CreateConfigMap(workspaceKey, iamAccessKey, iamSecretKey, workspace)
+	backend, err := core.ResolveBackend(workspace)
+	if err != nil {
+		return err
+	}
+	configMap, err := terraform.CreateConfigMap(workspaceKey, backend, workspace)
+	if err != nil {
+		return err
+	}
 	// Always pull new image for workspace
 	workspaceJob := terraform.CreateJob(workspaceKey, terraformCmd, workspace, true)
+	terraform.AddBackend(workspaceJob, backend)
 
 	// Set Workspace as owner of configmap and job object
 	if err := r.SetControllerReference(workspace, configMap); err != nil {
@@ -146,6 +175,18 @@ func (r *WorkspaceReconciler) startJob(jobName string, terraformCmd string, work
 		return err
 	}
 
+	if err := r.AddVariables(workspace, workspace, workspaceJob, configMap); err != nil {
+		return err
+	}
+
+	if err := r.AddCredentials(workspace, workspaceJob, backend, iamAccessKey, iamSecretKey); err != nil {
+		return err
+	}
+
+	if err := r.AddAssumeRole(workspace, workspaceJob, configMap); err != nil {
+		return err
+	}
+
 	// Create ConfigMap and Job
 	if err := r.CreateObject(workspaceKey, configMap, foundConfigMap); err != nil {
 		return err
@@ -157,6 +198,261 @@ func (r *WorkspaceReconciler) startJob(jobName string, terraformCmd string, work
 	return nil
 }
 
+// autoApply creates a Run applying the workspace when its spec has changed since it was last applied, and records the
+// outcome of the finished Run in the workspace status. It reports whether an apply is in progress. The Runs go
+// through the queue of the workspace like any other, so they never hold the state lock at the same time as another
+// Run or a drift check.
+func (r *WorkspaceReconciler) autoApply(workspace *terraformv1.Workspace) (ctrl.Result, bool, error) {
+	hash := core.WorkspaceSpecHash(workspace.Spec)
+	if hash == workspace.Status.LastAppliedHash {
+		return ctrl.Result{}, false, nil
+	}
+	// A failed apply is not retried until the spec changes, even once its Run has been deleted
+	if applied := core.FindCondition(workspace.Status.Conditions, terraformv1.WorkspaceApplied); applied != nil &&
+		applied.Reason == terraformv1.ApplyFailed && workspace.Status.ApplyHash == hash {
+		return ctrl.Result{}, false, nil
+	}
+	runKey := types.NamespacedName{Namespace: workspace.Namespace, Name: autoApplyRunName(workspace, hash)}
+
+	// A spec edited again while being applied is applied once the running Run finishes
+	if previous := workspace.Status.ApplyHash; previous != "" && previous != hash {
+		previousRun := &terraformv1.Run{}
+		previousKey := types.NamespacedName{Namespace: workspace.Namespace, Name: autoApplyRunName(workspace, previous)}
+		if err := r.Get(context.TODO(), previousKey, previousRun); err == nil {
+			if !core.RunFinished(previousRun) {
+				return ctrl.Result{RequeueAfter: autoApplyPollInterval}, true, nil
+			}
+		} else if !errors.IsNotFound(err) {
+			return ctrl.Result{}, false, err
+		}
+	}
+
+	run := &terraformv1.Run{}
+	if err := r.Get(context.TODO(), runKey, run); err != nil {
+		if !errors.IsNotFound(err) {
+			return ctrl.Result{}, false, err
+		}
+		log.Printf("Applying spec %s of %s/%s", hash, workspace.Namespace, workspace.Name)
+		run = &terraformv1.Run{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      runKey.Name,
+				Namespace: runKey.Namespace,
+				Labels:    make(map[string]string),
+			},
+			Spec: terraformv1.RunSpec{WorkspaceName: workspace.Name},
+		}
+		if err := r.SetControllerReference(workspace, run); err != nil {
+			return ctrl.Result{}, false, err
+		}
+		if err := r.Create(context.TODO(), run); err != nil && !errors.IsAlreadyExists(err) {
+			return ctrl.Result{}, false, err
+		}
+		workspace.Status.ApplyHash = hash
+		workspace.Status.Conditions = core.SetCondition(workspace.Status.Conditions, terraformv1.Condition{
+			Type:    terraformv1.WorkspaceApplied,
+			Status:  corev1.ConditionUnknown,
+			Reason:  terraformv1.ApplyInProgress,
+			Message: fmt.Sprintf("Applying spec %s - run/%s", hash, runKey.Name),
+		})
+		if err := r.Status().Update(context.Background(), workspace); err != nil {
+			return ctrl.Result{}, false, err
+		}
+		r.Recorder.Event(workspace, "Normal", terraformv1.ApplyInProgress, fmt.Sprintf("Applying spec %s", hash))
+		return ctrl.Result{RequeueAfter: autoApplyPollInterval}, true, nil
+	}
+
+	if !core.RunFinished(run) {
+		return ctrl.Result{RequeueAfter: autoApplyPollInterval}, true, nil
+	}
+	// The Run retrieves the state itself, so only the outcome is recorded
+	if run.Status.Phase == terraformv1.ObjSucceeded {
+		workspace.Status.LastAppliedHash = hash
+		workspace.Status.Conditions = core.SetCondition(workspace.Status.Conditions, terraformv1.Condition{
+			Type:    terraformv1.WorkspaceApplied,
+			Status:  corev1.ConditionTrue,
+			Reason:  terraformv1.ApplySucceeded,
+			Message: fmt.Sprintf("Applied spec %s", hash),
+		})
+		if err := r.Status().Update(context.Background(), workspace); err != nil {
+			return ctrl.Result{}, false, err
+		}
+		r.Recorder.Event(workspace, "Normal", terraformv1.ApplySucceeded, fmt.Sprintf("Applied spec %s", hash))
+		return ctrl.Result{}, false, nil
+	}
+	message := fmt.Sprintf("Applying spec %s failed - run/%s is %s", hash, runKey.Name, run.Status.Phase)
+	workspace.Status.Conditions = core.SetCondition(workspace.Status.Conditions, terraformv1.Condition{
+		Type:    terraformv1.WorkspaceApplied,
+		Status:  corev1.ConditionFalse,
+		Reason:  terraformv1.ApplyFailed,
+		Message: message,
+	})
+	if err := r.Status().Update(context.Background(), workspace); err != nil {
+		return ctrl.Result{}, false, err
+	}
+	r.Recorder.Event(workspace, "Warning", terraformv1.ApplyFailed, message)
+	return ctrl.Result{}, false, nil
+}
+
+// autoApplyRunName returns the name of the Run applying the spec of a workspace with the given hash
+func autoApplyRunName(workspace *terraformv1.Workspace, hash string) string {
+	return fmt.Sprintf("%s-apply-%s", workspace.Name, hash[:8])
+}
+
+// deleteJob deletes a job of the workspace along with its pods, configmap, and variables and credentials secrets
+func (r *WorkspaceReconciler) deleteJob(key types.NamespacedName) error {
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}}
+	if err := r.Delete(context.TODO(), job, client.PropagationPolicy(metav1.DeletePropagationBackground)); ignoreNotFound(err) != nil {
+		return err
+	}
+	configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}}
+	if err := r.Delete(context.TODO(), configMap); ignoreNotFound(err) != nil {
+		return err
+	}
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: terraform.VariablesSecretName(key.Name), Namespace: key.Namespace}}
+	if err := r.Delete(context.TODO(), secret); ignoreNotFound(err) != nil {
+		return err
+	}
+	credentials := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: terraform.CredentialsSecretName(key.Name), Namespace: key.Namespace}}
+	if err := r.Delete(context.TODO(), credentials); ignoreNotFound(err) != nil {
+		return err
+	}
+	return nil
+}
+
+// detectDrift starts a drift check when one is due according to the drift detection schedule, and records the
+// result of a finished check in the workspace status
+func (r *WorkspaceReconciler) detectDrift(workspace *terraformv1.Workspace) (ctrl.Result, error) {
+	jobName := driftJobName(workspace.Name)
+	jobKey := types.NamespacedName{Namespace: workspace.Namespace, Name: jobName}
+
+	schedule, err := cron.ParseStandard(workspace.Spec.DriftDetection.Schedule)
+	if err != nil {
+		workspace.Status.Conditions = core.SetCondition(workspace.Status.Conditions, terraformv1.Condition{
+			Type:    terraformv1.WorkspaceDrifted,
+			Status:  corev1.ConditionUnknown,
+			Reason:  terraformv1.InvalidSchedule,
+			Message: err.Error(),
+		})
+		if err := r.Status().Update(context.Background(), workspace); err != nil {
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Event(workspace, "Warning", terraformv1.InvalidSchedule, fmt.Sprintf("Invalid drift detection schedule - %s", err))
+		return ctrl.Result{}, nil
+	}
+
+	// Collect the result of the check in progress, if any
+	foundJob := &batchv1.Job{}
+	if err := r.Get(context.TODO(), jobKey, foundJob); err == nil {
+		if foundJob.Status.Succeeded == 0 && foundJob.Status.Failed == 0 {
+			return ctrl.Result{RequeueAfter: driftCheckPollInterval}, nil
+		}
+		if err := r.recordDrift(workspace, foundJob); err != nil {
+			return ctrl.Result{}, err
+		}
+		// Remove the finished check so the next one starts from a fresh job and configmap
+		if err := r.deleteJob(jobKey); err != nil {
+			return ctrl.Result{}, err
+		}
+	} else if !errors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+
+	lastCheck := workspace.CreationTimestamp
+	if workspace.Status.LastDriftCheck != nil {
+		lastCheck = *workspace.Status.LastDriftCheck
+	}
+	now := time.Now()
+	if next := schedule.Next(lastCheck.Time); now.Before(next) {
+		return ctrl.Result{RequeueAfter: next.Sub(now)}, nil
+	}
+
+	// A check planning while a run holds the state lock would fail the run, so due checks wait until every run
+	// against the workspace has finished, queued ones included, like the runs behind them in the queue
+	runList := &terraformv1.RunList{}
+	if err := r.List(context.Background(), runList, client.InNamespace(workspace.Namespace)); err != nil {
+		return ctrl.Result{}, err
+	}
+	if core.RunInProgress(workspace.Name, runList.Items) {
+		return ctrl.Result{RequeueAfter: driftCheckPollInterval}, nil
+	}
+
+	log.Printf("Starting drift check for %s/%s", workspace.Namespace, workspace.Name)
+	if err := r.startJob(jobName, core.TFDriftCheck, workspace); err != nil {
+		return ctrl.Result{}, err
+	}
+	workspace.Status.LastDriftCheck = &metav1.Time{Time: now}
+	if err := r.Status().Update(context.Background(), workspace); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: driftCheckPollInterval}, nil
+}
+
+// driftJobName returns the name of the job checking a workspace for drift
+func driftJobName(workspaceName string) string {
+	return fmt.Sprintf("%s-drift", workspaceName)
+}
+
+// recordDrift sets the Drifted condition of the workspace from the plan exit code and output of a drift check job
+func (r *WorkspaceReconciler) recordDrift(workspace *terraformv1.Workspace, job *batchv1.Job) error {
+	condition := terraformv1.Condition{
+		Type:    terraformv1.WorkspaceDrifted,
+		Status:  corev1.ConditionUnknown,
+		Reason:  terraformv1.DriftCheckFailed,
+		Message: fmt.Sprintf("Drift check job/%s failed", job.Name),
+	}
+	var driftedResources []string
+
+	podList := &corev1.PodList{}
+	podLabel := map[string]string{"job-name": job.Name}
+	if err := r.List(context.Background(), podList, client.InNamespace(job.Namespace), client.MatchingLabels(podLabel)); err != nil {
+		return err
+	}
+	for _, pod := range podList.Items {
+		if pod.Status.Phase != corev1.PodSucceeded || len(pod.Status.ContainerStatuses) == 0 {
+			continue
+		}
+		terminated := pod.Status.ContainerStatuses[0].State.Terminated
+		if terminated == nil {
+			continue
+		}
+		// terraform plan -detailed-exitcode exits with 0 when there are no changes and 2 when there are
+		switch strings.TrimSpace(terminated.Message) {
+		case "0":
+			condition.Status = corev1.ConditionFalse
+			condition.Reason = terraformv1.NoDriftDetected
+			condition.Message = "No changes found"
+		case "2":
+			logs, err := r.GetPodLogs(types.NamespacedName{Name: pod.Name, Namespace: pod.Namespace})
+			if err != nil {
+				return err
+			}
+			planJSON, err := terraform.PlanFromLogs(logs)
+			if err == nil {
+				driftedResources, err = terraform.DriftedAddresses(planJSON)
+			}
+			if err != nil {
+				log.Printf("Unable to read drifted resources of job/%s: %v", job.Name, err)
+			}
+			condition.Status = corev1.ConditionTrue
+			condition.Reason = terraformv1.DriftDetected
+			condition.Message = fmt.Sprintf("%d resources changed", len(driftedResources))
+		}
+		break
+	}
+
+	workspace.Status.Conditions = core.SetCondition(workspace.Status.Conditions, condition)
+	workspace.Status.DriftedResources = driftedResources
+	if err := r.Status().Update(context.Background(), workspace); err != nil {
+		return err
+	}
+	eventType := "Normal"
+	if condition.Status != corev1.ConditionFalse {
+		eventType = "Warning"
+	}
+	r.Recorder.Event(workspace, eventType, condition.Reason, condition.Message)
+	return nil
+}
+
 // workspaceCleanup removes workspace finalizer and directories created by workspace for storing tfstate
 func (r *WorkspaceReconciler) workspaceCleanup(finalizerName string, workspace *terraformv1.Workspace) error {
 	directoryPath := fmt.Sprintf("%s/%s", workspace.Namespace, workspace.Name)
@@ -177,15 +473,6 @@ func (r *WorkspaceReconciler) workspaceCleanup(finalizerName string, workspace *
 }
 
 func (r *WorkspaceReconciler) retrieveState(workspace *terraformv1.Workspace) error {
-	secret := &corev1.Secret{}
-
-	secretKey := types.NamespacedName{Namespace: core.ScipianNamespace, Name: core.ScipianIAMSecretName}
-	if err := r.GetSecret(secretKey, secret); err != nil {
-		return err
-	}
-	iamAccessKey := string(secret.Data[core.AccessKey])
-	iamSecretKey := string(secret.Data[core.SecretKey])
-
 	if err := r.Get(context.TODO(), types.NamespacedName{Name: workspace.Name, Namespace: workspace.Namespace}, workspace); err != nil {
 		return err
 	}
@@ -193,20 +480,28 @@ func (r *WorkspaceReconciler) retrieveState(workspace *terraformv1.Workspace) er
 	// Retrieve tfstate only if the job completed successfully
 	if workspace.Status.JobCompleted {
 		log.Printf("Retrieving tfstate")
-		state, err := core.RetrieveState(workspace, iamAccessKey, iamSecretKey)
+		state, err := r.ReadState(workspace)
 		if err != nil {
 			_ = r.updateStatus(workspace, terraformv1.ObjIncomplete, terraformv1.ErrRetriveTfstate, true)
 			r.Recorder.Event(workspace, "Warning", string(workspace.Status.Phase), "Error retrieving tfstate")
 			return fmt.Errorf("Error retrieving tfstate - %s", err)
 		}
-		workspace.Spec.TfState = state
+		if err := r.SaveState(workspace, state); err != nil {
+			_ = r.updateStatus(workspace, terraformv1.ObjIncomplete, terraformv1.ErrStoreTfstate, true)
+			r.Recorder.Event(workspace, "Warning", string(workspace.Status.Phase), "Error storing tfstate")
+			return fmt.Errorf("Error storing tfstate - %s", err)
+		}
+		if err := r.ExportOutputs(workspace, state); err != nil {
+			_ = r.updateStatus(workspace, terraformv1.ObjIncomplete, terraformv1.ErrExportOutputs, true)
+			r.Recorder.Event(workspace, "Warning", string(workspace.Status.Phase), "Error exporting outputs")
+			return fmt.Errorf("Error exporting outputs - %s", err)
+		}
+		// The job acquired and released the state lock
+		workspace.Status.StateLock = nil
 		if err := r.updateStatus(workspace, terraformv1.ObjSucceeded, terraformv1.WorkspaceCreated, true); err != nil {
 			return err
 		}
 		r.Recorder.Event(workspace, "Normal", string(workspace.Status.Phase), "Workspace created successfully")
-		if err := r.Update(context.Background(), workspace); err != nil {
-			return ignoreNotFound(err)
-		}
 		return nil
 	}
 	return nil
@@ -250,6 +545,13 @@ func (r *WorkspaceReconciler) checkJobStatus(jobName string, workspace *terrafor
 		return nil
 	case foundJob.Status.Failed == failedJobs:
 		log.Println("Job Failed")
+		if r.RecordStateLock(workspace, jobName) {
+			if err := r.updateStatus(workspace, terraformv1.ObjFailed, terraformv1.StateLocked, false); err != nil {
+				return err
+			}
+			r.Recorder.Event(workspace, "Warning", string(workspace.Status.Phase), lockMessage(workspace.Status.StateLock))
+			return fmt.Errorf("State locked")
+		}
 		if err := r.updateStatus(workspace, terraformv1.ObjFailed, terraformv1.JobFailed, false); err != nil {
 			return err
 		}
@@ -302,6 +604,9 @@ func (r *WorkspaceReconciler) checkPodStatus(pod *corev1.Pod, workspace *terrafo
 		if err := r.Get(context.Background(), podKey, pod); err != nil {
 			return err
 		}
+		if revision := terraform.SourceRevision(pod); revision != "" {
+			workspace.Status.SourceRevision = revision
+		}
 		switch pod.Status.Phase {
 		case corev1.PodPending:
 			for _, containerStatus := range pod.Status.ContainerStatuses {
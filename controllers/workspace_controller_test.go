@@ -19,15 +19,11 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	terraformv1 "github.com/scipian/terraform-controller/api/v1"
@@ -70,7 +66,7 @@ var _ = Describe("WorkspaceController", func() {
 				WorkingDir: "/foo",
 				Region:     "us-west-2",
 				EnvVars:    map[string]string{"FOO": "foo"},
-				TfVars:     map[string]string{"BAR": "bar"},
+				TfVars:     map[string]terraformv1.TfVar{"BAR": {Raw: []byte(`"bar"`)}},
 			},
 		}
 
@@ -193,9 +189,6 @@ var _ = Describe("WorkspaceController", func() {
 			By("Retrieving TF State", func() {
 				workspace := &terraformv1.Workspace{}
 				foundWorkspaceJob := &batchv1.Job{}
-				s3Bucket := os.Getenv("SCIPIAN_STATE_BUCKET")
-				filePath := fmt.Sprintf("%s/%s/%s", "foo", "bar", core.TFStateFileName)
-				directoryPath := fmt.Sprintf("%s/%s", "foo", "bar")
 
 				Eventually(func() error {
 					return k8sClient.Get(ctx, workspaceKey, workspace)
@@ -205,38 +198,23 @@ var _ = Describe("WorkspaceController", func() {
 					return k8sClient.Get(ctx, workspaceKey, foundWorkspaceJob)
 				}, timeout, interval).Should(BeNil())
 
-				//Create a test client and mock aws session
-				client, _ := core.CustomClientWithCertPool()
+				//Mock the state bucket
+				var requested string
 				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					requested = r.URL.Path
 					w.WriteHeader(http.StatusOK)
 					io.WriteString(w, "Test data")
 				}))
+				defer server.Close()
 
-				sess := session.Must(session.NewSession(&aws.Config{
-					DisableSSL:       aws.Bool(true),
-					Endpoint:         aws.String(server.URL),
-					Region:           aws.String("test-region"),
-					S3ForcePathStyle: aws.Bool(true),
-					HTTPClient:       client,
-				}))
-
-				//Download tfstate
-				downloader := s3manager.NewDownloader(sess)
-				err := core.S3Puller(s3Bucket, filePath, downloader, directoryPath)
+				//Read tfstate and update workspace
+				store := &core.S3StateStore{Bucket: "state", Region: "test-region", Endpoint: server.URL, AccessKey: "test-key", SecretKey: "test-secret"}
+				state, err := store.Get(types.NamespacedName{Namespace: "foo", Name: "bar"}, "")
 				Expect(err).ToNot(HaveOccurred())
-
-				//Read tfstate file and update workspace
-				jsonFile, err := os.Open(filePath)
-				Expect(err).ToNot(HaveOccurred())
-				defer jsonFile.Close()
-				byteValue, _ := ioutil.ReadAll(jsonFile)
-				state := string(byteValue)
+				Expect(requested).To(Equal(fmt.Sprintf("/state/foo/bar/%s", core.TFStateFileName)))
 				workspace.Spec.TfState = state
 				Expect(k8sClient.Update(context.Background(), workspace)).Should(Succeed())
-				os.Remove(filePath)
-				os.Remove(directoryPath)
 			})
-
 		})
 
 	})
@@ -0,0 +1,55 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// staticCredentials returns the credentials provider of a session signing with static keys, or nil for the default
+// credentials of the controller when both are empty. The keys are never put in the environment of the process, which
+// every session would read.
+func staticCredentials(accessKey string, secretKey string) *credentials.Credentials {
+	if accessKey == "" && secretKey == "" {
+		return nil
+	}
+	return credentials.NewStaticCredentials(accessKey, secretKey, "")
+}
+
+// assumeRole makes sessions created with config assume roleARN, passing externalID if set, with the credentials the
+// config would otherwise use: static keys, or the default chain, which includes the web identity of the
+// ServiceAccount of the controller (IRSA). It does nothing if roleARN is empty. It has to be called before the
+// endpoint of an S3-compatible store is set, as the role is assumed through AWS STS.
+func assumeRole(config *aws.Config, roleARN string, externalID string) error {
+	if roleARN == "" {
+		return nil
+	}
+	sess, err := session.NewSession(config.Copy())
+	if err != nil {
+		return fmt.Errorf("failed to create session to assume %s: %v", roleARN, err)
+	}
+	config.Credentials = stscreds.NewCredentials(sess, roleARN, func(p *stscreds.AssumeRoleProvider) {
+		if externalID != "" {
+			p.ExternalID = aws.String(externalID)
+		}
+	})
+	return nil
+}
@@ -0,0 +1,74 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AWS credentials", func() {
+	It("assumes a role with an external ID", func() {
+		var assumed []string
+		var signedWith []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost && r.FormValue("Action") == "AssumeRole" {
+				assumed = append(assumed, r.FormValue("RoleArn")+" "+r.FormValue("ExternalId"))
+				fmt.Fprint(w, `<AssumeRoleResponse><AssumeRoleResult><Credentials>`+
+					`<AccessKeyId>ASIAASSUMED</AccessKeyId><SecretAccessKey>assumed</SecretAccessKey>`+
+					`<SessionToken>token</SessionToken><Expiration>2099-01-01T00:00:00Z</Expiration>`+
+					`</Credentials></AssumeRoleResult></AssumeRoleResponse>`)
+				return
+			}
+			signedWith = append(signedWith, strings.SplitN(r.Header.Get("Authorization"), "/", 2)[0])
+			fmt.Fprint(w, `{"serial": 1}`)
+		}))
+		defer server.Close()
+
+		// The fake serves STS and S3 on the same endpoint
+		config := &aws.Config{
+			Region:           aws.String("us-west-2"),
+			Endpoint:         aws.String(server.URL),
+			S3ForcePathStyle: aws.Bool(true),
+			Credentials:      credentials.NewStaticCredentials("static", "key", ""),
+		}
+		Expect(assumeRole(config, "arn:aws:iam::123456789012:role/scipian-state", "scipian")).To(Succeed())
+		sess, err := session.NewSession(config)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = s3.New(sess).GetObject(&s3.GetObjectInput{Bucket: aws.String("state"), Key: aws.String("team-a/vpc/terraform.tfstate")})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(assumed).To(Equal([]string{"arn:aws:iam::123456789012:role/scipian-state scipian"}))
+		Expect(signedWith).To(Equal([]string{"AWS4-HMAC-SHA256 Credential=ASIAASSUMED"}))
+	})
+
+	It("keeps the credentials of the config without a role", func() {
+		config := &aws.Config{Credentials: credentials.NewStaticCredentials("static", "key", "")}
+		Expect(assumeRole(config, "", "scipian")).To(Succeed())
+		value, err := config.Credentials.Get()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(value.AccessKeyID).To(Equal("static"))
+	})
+})
@@ -0,0 +1,172 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+)
+
+// Backend types, as selected by SCIPIAN_BACKEND
+const (
+	S3Backend         = "s3"
+	KubernetesBackend = "kubernetes"
+	HTTPBackend       = "http"
+	LocalBackend      = "local"
+
+	// DefaultSecretSuffix names the state Secrets of the kubernetes backend
+	DefaultSecretSuffix = "state"
+)
+
+// BackendType returns the type of a backend
+func BackendType(backend *terraformv1.Backend) string {
+	switch {
+	case backend.S3 != nil:
+		return S3Backend
+	case backend.Kubernetes != nil:
+		return KubernetesBackend
+	case backend.HTTP != nil:
+		return HTTPBackend
+	case backend.Local != nil:
+		return LocalBackend
+	}
+	return ""
+}
+
+// ResolveBackend returns the backend a workspace keeps its state in, with the settings it leaves empty taken from the
+// controller. Workspaces without a backend use the one selected by SCIPIAN_BACKEND, which defaults to s3.
+//   - s3 uses SCIPIAN_STATE_BUCKET, locked by SCIPIAN_STATE_LOCKING, through SCIPIAN_S3_ENDPOINT if set
+//
+// The bucket and role of s3 are used with the identity of the controller, so a workspace can only give its own if
+// they are listed in SCIPIAN_STATE_ALLOWED_BUCKETS and SCIPIAN_STATE_ALLOWED_ROLE_ARNS.
+//   - http uses SCIPIAN_HTTP_BACKEND_ADDRESS, locked by SCIPIAN_HTTP_BACKEND_LOCK_ADDRESS if set
+//   - local uses the PersistentVolumeClaim SCIPIAN_STATE_CLAIM
+func ResolveBackend(workspace *terraformv1.Workspace) (*terraformv1.Backend, error) {
+	backend := &terraformv1.Backend{}
+	if workspace.Spec.Backend != nil {
+		backend = workspace.Spec.Backend.DeepCopy()
+		set := 0
+		for _, configured := range []bool{backend.S3 != nil, backend.Kubernetes != nil, backend.HTTP != nil, backend.Local != nil} {
+			if configured {
+				set++
+			}
+		}
+		if set != 1 {
+			return nil, fmt.Errorf("workspace/%s must set exactly one backend", workspace.Name)
+		}
+	} else {
+		switch backendType := os.Getenv("SCIPIAN_BACKEND"); backendType {
+		case "", S3Backend:
+			backend.S3 = &terraformv1.S3Backend{}
+		case KubernetesBackend:
+			backend.Kubernetes = &terraformv1.KubernetesBackend{}
+		case HTTPBackend:
+			backend.HTTP = &terraformv1.HTTPBackend{}
+		case LocalBackend:
+			backend.Local = &terraformv1.LocalBackend{}
+		default:
+			return nil, fmt.Errorf("Error: unknown SCIPIAN_BACKEND %q", backendType)
+		}
+	}
+
+	switch {
+	case backend.S3 != nil:
+		s3 := backend.S3
+		if s3.Bucket == "" {
+			s3.Bucket = os.Getenv("SCIPIAN_STATE_BUCKET")
+		} else if !controllerAllows(s3.Bucket, "SCIPIAN_STATE_BUCKET", "SCIPIAN_STATE_ALLOWED_BUCKETS") {
+			return nil, fmt.Errorf("workspace/%s may not keep its state in bucket %q - it is not in SCIPIAN_STATE_ALLOWED_BUCKETS",
+				workspace.Name, s3.Bucket)
+		}
+		if s3.Bucket == "" {
+			return nil, fmt.Errorf("Error: Env variable SCIPIAN_STATE_BUCKET not set")
+		}
+		if s3.Endpoint == "" {
+			s3.Endpoint = os.Getenv("SCIPIAN_S3_ENDPOINT")
+		}
+		if s3.DynamoDBTable == "" {
+			s3.DynamoDBTable = os.Getenv("SCIPIAN_STATE_LOCKING")
+		}
+		// S3-compatible stores have no DynamoDB to lock with
+		if s3.DynamoDBTable == "" && s3.Endpoint == "" {
+			s3.DynamoDBTable = fmt.Sprintf("%s-locking", s3.Bucket)
+		}
+		if s3.Region == "" {
+			s3.Region = stateRegion(workspace.Spec.Region)
+		}
+		if s3.RoleARN == "" {
+			s3.RoleARN = os.Getenv("SCIPIAN_STATE_ROLE_ARN")
+			if s3.ExternalID == "" {
+				s3.ExternalID = os.Getenv("SCIPIAN_STATE_EXTERNAL_ID")
+			}
+		} else if !controllerAllows(s3.RoleARN, "SCIPIAN_STATE_ROLE_ARN", "SCIPIAN_STATE_ALLOWED_ROLE_ARNS") {
+			return nil, fmt.Errorf("workspace/%s may not assume role %q for its state - it is not in SCIPIAN_STATE_ALLOWED_ROLE_ARNS",
+				workspace.Name, s3.RoleARN)
+		}
+	case backend.Kubernetes != nil:
+		if backend.Kubernetes.SecretSuffix == "" {
+			backend.Kubernetes.SecretSuffix = DefaultSecretSuffix
+		}
+	case backend.HTTP != nil:
+		http := backend.HTTP
+		if http.Address == "" {
+			http.Address = os.Getenv("SCIPIAN_HTTP_BACKEND_ADDRESS")
+			if http.LockAddress == "" {
+				http.LockAddress = os.Getenv("SCIPIAN_HTTP_BACKEND_LOCK_ADDRESS")
+			}
+		}
+		if http.Address == "" {
+			return nil, fmt.Errorf("Error: Env variable SCIPIAN_HTTP_BACKEND_ADDRESS not set")
+		}
+		if http.UnlockAddress == "" {
+			http.UnlockAddress = http.LockAddress
+		}
+	case backend.Local != nil:
+		if backend.Local.ClaimName == "" {
+			backend.Local.ClaimName = os.Getenv("SCIPIAN_STATE_CLAIM")
+		}
+		if backend.Local.ClaimName == "" {
+			return nil, fmt.Errorf("Error: Env variable SCIPIAN_STATE_CLAIM not set")
+		}
+	}
+	return backend, nil
+}
+
+// controllerAllows reports whether a setting given by a workspace is the default of the controller in env, or is in the
+// comma separated allowlist of the controller in allowlistEnv
+func controllerAllows(value, env, allowlistEnv string) bool {
+	if value == os.Getenv(env) {
+		return true
+	}
+	for _, allowed := range strings.Split(os.Getenv(allowlistEnv), ",") {
+		if strings.TrimSpace(allowed) == value {
+			return true
+		}
+	}
+	return false
+}
+
+// stateRegion returns the region of the state bucket for a workspace in the given region. AWS China is a separate
+// partition with its own bucket.
+func stateRegion(region string) string {
+	if region == "cn-north-1" || region == "cn-northwest-1" {
+		return "cn-north-1"
+	}
+	return "us-west-2"
+}
@@ -0,0 +1,218 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Backend", func() {
+	backendEnv := []string{
+		"SCIPIAN_BACKEND",
+		"SCIPIAN_STATE_BUCKET",
+		"SCIPIAN_STATE_LOCKING",
+		"SCIPIAN_S3_ENDPOINT",
+		"SCIPIAN_HTTP_BACKEND_ADDRESS",
+		"SCIPIAN_HTTP_BACKEND_LOCK_ADDRESS",
+		"SCIPIAN_STATE_CLAIM",
+		"SCIPIAN_STATE_ROLE_ARN",
+		"SCIPIAN_STATE_EXTERNAL_ID",
+		"SCIPIAN_STATE_ALLOWED_BUCKETS",
+		"SCIPIAN_STATE_ALLOWED_ROLE_ARNS",
+	}
+	saved := map[string]string{}
+	newWorkspace := func(backend *terraformv1.Backend) *terraformv1.Workspace {
+		return &terraformv1.Workspace{
+			ObjectMeta: metav1.ObjectMeta{Name: "vpc", Namespace: "team-a"},
+			Spec:       terraformv1.WorkspaceSpec{Region: "us-east-1", Backend: backend},
+		}
+	}
+
+	BeforeEach(func() {
+		for _, name := range backendEnv {
+			if value, set := os.LookupEnv(name); set {
+				saved[name] = value
+			}
+			os.Unsetenv(name)
+		}
+	})
+
+	AfterEach(func() {
+		for _, name := range backendEnv {
+			os.Unsetenv(name)
+			if value, set := saved[name]; set {
+				os.Setenv(name, value)
+			}
+		}
+	})
+
+	Context("ResolveBackend", func() {
+		It("defaults to the S3 bucket of the controller", func() {
+			os.Setenv("SCIPIAN_STATE_BUCKET", "scipian-state")
+			backend, err := ResolveBackend(newWorkspace(nil))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(BackendType(backend)).To(Equal(S3Backend))
+			Expect(backend.S3).To(Equal(&terraformv1.S3Backend{
+				Bucket:        "scipian-state",
+				Region:        "us-west-2",
+				DynamoDBTable: "scipian-state-locking",
+			}))
+		})
+
+		It("keeps the state of AWS China workspaces in AWS China", func() {
+			os.Setenv("SCIPIAN_STATE_BUCKET", "scipian-state")
+			workspace := newWorkspace(nil)
+			workspace.Spec.Region = "cn-northwest-1"
+			backend, err := ResolveBackend(workspace)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(backend.S3.Region).To(Equal("cn-north-1"))
+		})
+
+		It("assumes the role of the controller unless given one", func() {
+			os.Setenv("SCIPIAN_STATE_BUCKET", "scipian-state")
+			os.Setenv("SCIPIAN_STATE_ROLE_ARN", "arn:aws:iam::123456789012:role/scipian-state")
+			os.Setenv("SCIPIAN_STATE_EXTERNAL_ID", "scipian")
+			backend, err := ResolveBackend(newWorkspace(nil))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(backend.S3.RoleARN).To(Equal("arn:aws:iam::123456789012:role/scipian-state"))
+			Expect(backend.S3.ExternalID).To(Equal("scipian"))
+
+			os.Setenv("SCIPIAN_STATE_ALLOWED_ROLE_ARNS", "arn:aws:iam::210987654321:role/team-a-state")
+			backend, err = ResolveBackend(newWorkspace(&terraformv1.Backend{S3: &terraformv1.S3Backend{
+				RoleARN: "arn:aws:iam::210987654321:role/team-a-state",
+			}}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(backend.S3.RoleARN).To(Equal("arn:aws:iam::210987654321:role/team-a-state"))
+			Expect(backend.S3.ExternalID).To(BeEmpty())
+		})
+
+		It("rejects a bucket or role the controller does not allow", func() {
+			os.Setenv("SCIPIAN_STATE_BUCKET", "scipian-state")
+			os.Setenv("SCIPIAN_STATE_ROLE_ARN", "arn:aws:iam::123456789012:role/scipian-state")
+			os.Setenv("SCIPIAN_STATE_ALLOWED_BUCKETS", "team-a-state, team-b-state")
+			os.Setenv("SCIPIAN_STATE_ALLOWED_ROLE_ARNS", "arn:aws:iam::210987654321:role/team-a-state")
+
+			_, err := ResolveBackend(newWorkspace(&terraformv1.Backend{S3: &terraformv1.S3Backend{
+				RoleARN: "arn:aws:iam::123456789012:role/admin",
+			}}))
+			Expect(err).To(MatchError(ContainSubstring("SCIPIAN_STATE_ALLOWED_ROLE_ARNS")))
+
+			_, err = ResolveBackend(newWorkspace(&terraformv1.Backend{S3: &terraformv1.S3Backend{Bucket: "team-c-state"}}))
+			Expect(err).To(MatchError(ContainSubstring("SCIPIAN_STATE_ALLOWED_BUCKETS")))
+
+			backend, err := ResolveBackend(newWorkspace(&terraformv1.Backend{S3: &terraformv1.S3Backend{
+				Bucket:  "team-b-state",
+				RoleARN: "arn:aws:iam::123456789012:role/scipian-state",
+			}}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(backend.S3.Bucket).To(Equal("team-b-state"))
+		})
+
+		It("does not lock S3-compatible stores unless given a table", func() {
+			os.Setenv("SCIPIAN_STATE_BUCKET", "scipian-state")
+			os.Setenv("SCIPIAN_S3_ENDPOINT", "http://minio.minio:9000")
+			backend, err := ResolveBackend(newWorkspace(nil))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(backend.S3.Endpoint).To(Equal("http://minio.minio:9000"))
+			Expect(backend.S3.DynamoDBTable).To(BeEmpty())
+
+			os.Setenv("SCIPIAN_STATE_LOCKING", "scipian-locks")
+			backend, err = ResolveBackend(newWorkspace(nil))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(backend.S3.DynamoDBTable).To(Equal("scipian-locks"))
+		})
+
+		It("fills in the settings a workspace leaves empty", func() {
+			os.Setenv("SCIPIAN_BACKEND", KubernetesBackend)
+			os.Setenv("SCIPIAN_STATE_BUCKET", "scipian-state")
+			os.Setenv("SCIPIAN_STATE_ALLOWED_BUCKETS", "team-a-state")
+			backend, err := ResolveBackend(newWorkspace(&terraformv1.Backend{S3: &terraformv1.S3Backend{Bucket: "team-a-state"}}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(backend.S3).To(Equal(&terraformv1.S3Backend{
+				Bucket:        "team-a-state",
+				Region:        "us-west-2",
+				DynamoDBTable: "team-a-state-locking",
+			}))
+		})
+
+		It("does not change the workspace", func() {
+			workspace := newWorkspace(&terraformv1.Backend{Kubernetes: &terraformv1.KubernetesBackend{}})
+			backend, err := ResolveBackend(workspace)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(backend.Kubernetes.SecretSuffix).To(Equal(DefaultSecretSuffix))
+			Expect(workspace.Spec.Backend.Kubernetes.SecretSuffix).To(BeEmpty())
+		})
+
+		It("selects the backend of the controller", func() {
+			os.Setenv("SCIPIAN_BACKEND", HTTPBackend)
+			os.Setenv("SCIPIAN_HTTP_BACKEND_ADDRESS", "https://state.example.com/state")
+			os.Setenv("SCIPIAN_HTTP_BACKEND_LOCK_ADDRESS", "https://state.example.com/lock")
+			backend, err := ResolveBackend(newWorkspace(nil))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(backend.HTTP).To(Equal(&terraformv1.HTTPBackend{
+				Address:       "https://state.example.com/state",
+				LockAddress:   "https://state.example.com/lock",
+				UnlockAddress: "https://state.example.com/lock",
+			}))
+
+			os.Setenv("SCIPIAN_BACKEND", LocalBackend)
+			os.Setenv("SCIPIAN_STATE_CLAIM", "terraform-state")
+			backend, err = ResolveBackend(newWorkspace(nil))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(backend.Local.ClaimName).To(Equal("terraform-state"))
+		})
+
+		It("does not lock the address of a workspace with the lock address of the controller", func() {
+			os.Setenv("SCIPIAN_HTTP_BACKEND_LOCK_ADDRESS", "https://state.example.com/lock")
+			backend, err := ResolveBackend(newWorkspace(&terraformv1.Backend{HTTP: &terraformv1.HTTPBackend{Address: "https://other.example.com"}}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(backend.HTTP.LockAddress).To(BeEmpty())
+		})
+
+		It("fails without the settings a backend needs", func() {
+			_, err := ResolveBackend(newWorkspace(nil))
+			Expect(err).To(HaveOccurred())
+
+			_, err = ResolveBackend(newWorkspace(&terraformv1.Backend{HTTP: &terraformv1.HTTPBackend{}}))
+			Expect(err).To(HaveOccurred())
+
+			_, err = ResolveBackend(newWorkspace(&terraformv1.Backend{Local: &terraformv1.LocalBackend{}}))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("fails unless exactly one backend is set", func() {
+			_, err := ResolveBackend(newWorkspace(&terraformv1.Backend{}))
+			Expect(err).To(HaveOccurred())
+
+			_, err = ResolveBackend(newWorkspace(&terraformv1.Backend{
+				Kubernetes: &terraformv1.KubernetesBackend{},
+				Local:      &terraformv1.LocalBackend{ClaimName: "terraform-state"},
+			}))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("fails on an unknown backend", func() {
+			os.Setenv("SCIPIAN_BACKEND", "consul")
+			_, err := ResolveBackend(newWorkspace(nil))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,52 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SetCondition adds the condition to the given conditions, replacing any condition of the same type. The transition
+// time is only updated when the status of the condition changes.
+func SetCondition(conditions []terraformv1.Condition, condition terraformv1.Condition) []terraformv1.Condition {
+	for i, existing := range conditions {
+		if existing.Type != condition.Type {
+			continue
+		}
+		if existing.Status == condition.Status {
+			condition.LastTransitionTime = existing.LastTransitionTime
+		} else {
+			condition.LastTransitionTime = metav1.Now()
+		}
+		conditions[i] = condition
+		return conditions
+	}
+
+	// Conditions doesn't contain the condition, so add it
+	condition.LastTransitionTime = metav1.Now()
+	return append(conditions, condition)
+}
+
+// FindCondition returns the condition of the given type, or nil if there is none
+func FindCondition(conditions []terraformv1.Condition, conditionType terraformv1.ConditionType) *terraformv1.Condition {
+	for i := range conditions {
+		if conditions[i].Type == conditionType {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Conditions", func() {
+	past := metav1.NewTime(time.Now().Add(-time.Hour))
+	conditions := []terraformv1.Condition{
+		{
+			Type:               terraformv1.WorkspaceDrifted,
+			Status:             corev1.ConditionFalse,
+			Reason:             terraformv1.NoDriftDetected,
+			LastTransitionTime: past,
+		},
+	}
+
+	Context("SetCondition", func() {
+		It("keeps the transition time when the status does not change", func() {
+			conditions = SetCondition(conditions, terraformv1.Condition{
+				Type:    terraformv1.WorkspaceDrifted,
+				Status:  corev1.ConditionFalse,
+				Reason:  terraformv1.NoDriftDetected,
+				Message: "checked again",
+			})
+
+			Expect(conditions).To(HaveLen(1))
+			Expect(conditions[0].Message).To(Equal("checked again"))
+			Expect(conditions[0].LastTransitionTime).To(Equal(past))
+		})
+
+		It("updates the transition time when the status changes", func() {
+			conditions = SetCondition(conditions, terraformv1.Condition{
+				Type:   terraformv1.WorkspaceDrifted,
+				Status: corev1.ConditionTrue,
+				Reason: terraformv1.DriftDetected,
+			})
+
+			Expect(conditions).To(HaveLen(1))
+			Expect(conditions[0].Status).To(Equal(corev1.ConditionTrue))
+			Expect(conditions[0].LastTransitionTime.After(past.Time)).To(BeTrue())
+		})
+
+		It("adds a condition of a new type", func() {
+			conditions = SetCondition(conditions, terraformv1.Condition{
+				Type:   "Foo",
+				Status: corev1.ConditionTrue,
+			})
+
+			Expect(conditions).To(HaveLen(2))
+			Expect(conditions[1].LastTransitionTime.IsZero()).To(BeFalse())
+		})
+	})
+
+	Context("FindCondition", func() {
+		It("returns the condition of the given type", func() {
+			Expect(FindCondition(conditions, terraformv1.WorkspaceDrifted).Reason).To(Equal(terraformv1.DriftDetected))
+		})
+
+		It("returns nil if there is no condition of the given type", func() {
+			Expect(FindCondition(conditions, "Bar")).To(BeNil())
+		})
+	})
+})
@@ -0,0 +1,53 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+)
+
+// RunSpecHash hashes the fields of a run spec that decide what its jobs execute. Approving or cancelling a run does
+// not change the hash.
+func RunSpecHash(spec terraformv1.RunSpec) string {
+	executed := spec.DeepCopy()
+	executed.Approved = false
+	executed.Cancel = false
+	executed.TTLSecondsAfterFinished = nil
+	executed.ApprovalTimeoutSeconds = nil
+	return specHash(executed)
+}
+
+// WorkspaceSpecHash hashes the fields of a workspace spec that decide what Terraform applies. The retrieved state and
+// the settings of the controller features do not change the hash.
+func WorkspaceSpecHash(spec terraformv1.WorkspaceSpec) string {
+	applied := spec.DeepCopy()
+	applied.TfState = ""
+	applied.Outputs = nil
+	applied.DriftDetection = nil
+	applied.AutoApply = false
+	return specHash(applied)
+}
+
+// specHash hashes the JSON encoding of a spec, which lists map keys in sorted order
+func specHash(spec interface{}) string {
+	data, _ := json.Marshal(spec)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,64 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+)
+
+var _ = Describe("Hash", func() {
+	spec := terraformv1.WorkspaceSpec{
+		Image:      "quay.io/scipian/aws-vpc:v1",
+		WorkingDir: "/terraform",
+		Region:     "us-west-2",
+		TfVars: map[string]terraformv1.TfVar{
+			"cidr": {Raw: []byte(`"10.0.0.0/16"`)},
+			"name": {Raw: []byte(`"test"`)},
+		},
+	}
+
+	Context("WorkspaceSpecHash", func() {
+		It("does not depend on the order of variables", func() {
+			reordered := spec.DeepCopy()
+			reordered.TfVars = map[string]terraformv1.TfVar{
+				"name": {Raw: []byte(`"test"`)},
+				"cidr": {Raw: []byte(`"10.0.0.0/16"`)},
+			}
+			Expect(WorkspaceSpecHash(*reordered)).To(Equal(WorkspaceSpecHash(spec)))
+		})
+
+		It("ignores the state and controller features", func() {
+			updated := spec.DeepCopy()
+			updated.TfState = `{"version": 4}`
+			updated.AutoApply = true
+			updated.DriftDetection = &terraformv1.DriftDetection{Schedule: "@hourly"}
+			updated.Outputs = &terraformv1.OutputsSink{SecretName: "outputs"}
+			Expect(WorkspaceSpecHash(*updated)).To(Equal(WorkspaceSpecHash(spec)))
+		})
+
+		It("changes with the variables and image", func() {
+			updated := spec.DeepCopy()
+			updated.TfVars["cidr"] = terraformv1.TfVar{Raw: []byte(`"10.1.0.0/16"`)}
+			Expect(WorkspaceSpecHash(*updated)).NotTo(Equal(WorkspaceSpecHash(spec)))
+
+			updated = spec.DeepCopy()
+			updated.Image = "quay.io/scipian/aws-vpc:v2"
+			Expect(WorkspaceSpecHash(*updated)).NotTo(Equal(WorkspaceSpecHash(spec)))
+		})
+	})
+})
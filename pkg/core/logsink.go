@@ -0,0 +1,212 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// LogChunkSize is the largest part of a log stored in a single ConfigMap, well below the 1MiB ConfigMap limit
+	LogChunkSize = 512 * 1024
+
+	// LogKey is the ConfigMap key holding a chunk of a log
+	LogKey = "log"
+
+	// RunLabel labels the objects stored for a Run with the name of the Run
+	RunLabel = "terraform.scipian.io/run"
+)
+
+// LogSink stores the Terraform logs of Run pods, so they outlive the pods
+type LogSink interface {
+	// Store saves the log of a pod of the run and returns where it was stored
+	Store(run *terraformv1.Run, pod string, logs string) (terraformv1.LogReference, error)
+}
+
+// ConfigMapSink splits logs across ConfigMaps owned by the Run, which are garbage collected with it
+type ConfigMapSink struct {
+	Client    client.Client
+	Scheme    *runtime.Scheme
+	ChunkSize int
+}
+
+// Store saves the log of a pod in ConfigMaps named <pod>-log-0 and up
+func (s *ConfigMapSink) Store(run *terraformv1.Run, pod string, logs string) (terraformv1.LogReference, error) {
+	chunkSize := s.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = LogChunkSize
+	}
+	prefix := fmt.Sprintf("%s-log", pod)
+	chunks := ChunkLogs(logs, chunkSize)
+	for i, chunk := range chunks {
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("%s-%d", prefix, i),
+				Namespace: run.Namespace,
+			},
+		}
+		chunk := chunk
+		if _, err := controllerutil.CreateOrUpdate(context.Background(), s.Client, configMap, func() error {
+			configMap.Labels = map[string]string{RunLabel: run.Name}
+			configMap.Data = map[string]string{LogKey: chunk}
+			return controllerutil.SetControllerReference(run, configMap, s.Scheme)
+		}); err != nil {
+			return terraformv1.LogReference{}, fmt.Errorf("failed to store log chunk %d: %v", i, err)
+		}
+	}
+	return terraformv1.LogReference{
+		Pod:      pod,
+		Sink:     terraformv1.ConfigMapLogSink,
+		Location: prefix,
+		Chunks:   len(chunks),
+	}, nil
+}
+
+// DirectorySink writes logs to files under a directory, such as a PersistentVolumeClaim mounted into the controller
+type DirectorySink struct {
+	Dir string
+}
+
+// Store saves the log of a pod to <dir>/<namespace>/<run>/<pod>.log
+func (s *DirectorySink) Store(run *terraformv1.Run, pod string, logs string) (terraformv1.LogReference, error) {
+	dir := filepath.Join(s.Dir, run.Namespace, run.Name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return terraformv1.LogReference{}, fmt.Errorf("failed to create log directory: %v", err)
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s.log", pod))
+	if err := ioutil.WriteFile(path, []byte(logs), 0644); err != nil {
+		return terraformv1.LogReference{}, fmt.Errorf("failed to write log: %v", err)
+	}
+	return terraformv1.LogReference{Pod: pod, Sink: terraformv1.DirectoryLogSink, Location: path}, nil
+}
+
+// S3Sink uploads logs to an S3 bucket, next to the state of the Workspace. Endpoint points it at an S3-compatible
+// store such as MinIO instead of AWS. RoleARN is assumed to upload them if set.
+type S3Sink struct {
+	Bucket     string
+	Endpoint   string
+	AccessKey  string
+	SecretKey  string
+	RoleARN    string
+	ExternalID string
+}
+
+// Store uploads the log of a pod to <namespace>/<workspace>/logs/<run>/<pod>.log
+func (s *S3Sink) Store(run *terraformv1.Run, pod string, logs string) (terraformv1.LogReference, error) {
+	sess, err := s.session()
+	if err != nil {
+		return terraformv1.LogReference{}, err
+	}
+	key := fmt.Sprintf("%s/%s/logs/%s/%s.log", run.Namespace, run.Spec.WorkspaceName, run.Name, pod)
+	uploader := s3manager.NewUploader(sess)
+	if _, err := uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+		Body:   strings.NewReader(logs),
+	}); err != nil {
+		return terraformv1.LogReference{}, fmt.Errorf("failed to upload log: %v", err)
+	}
+	return terraformv1.LogReference{
+		Pod:      pod,
+		Sink:     terraformv1.S3LogSink,
+		Location: fmt.Sprintf("s3://%s/%s", s.Bucket, key),
+	}, nil
+}
+
+// session creates an AWS session for the sink, using path-style addressing for custom endpoints
+func (s *S3Sink) session() (*session.Session, error) {
+	// Without keys, the default credentials of the controller are used
+	return createNewSession("us-west-2", s.Endpoint, staticCredentials(s.AccessKey, s.SecretKey), s.RoleARN, s.ExternalID)
+}
+
+// LogSinkFromEnv creates the log sink selected by SCIPIAN_LOG_SINK, which defaults to configmap. It returns nil when
+// SCIPIAN_LOG_SINK is none.
+//   - directory writes under SCIPIAN_LOG_DIR
+//   - s3 uploads to SCIPIAN_LOG_BUCKET, or SCIPIAN_STATE_BUCKET if unset, through SCIPIAN_S3_ENDPOINT if set, as
+//     SCIPIAN_STATE_ROLE_ARN if set
+func LogSinkFromEnv(c client.Client, scheme *runtime.Scheme, accessKey string, secretKey string) (LogSink, error) {
+	switch sink := os.Getenv("SCIPIAN_LOG_SINK"); sink {
+	case "", string(terraformv1.ConfigMapLogSink):
+		return &ConfigMapSink{Client: c, Scheme: scheme}, nil
+	case string(terraformv1.DirectoryLogSink):
+		dir, set := os.LookupEnv("SCIPIAN_LOG_DIR")
+		if !set || dir == "" {
+			return nil, fmt.Errorf("Error: Env variable SCIPIAN_LOG_DIR not set")
+		}
+		return &DirectorySink{Dir: dir}, nil
+	case string(terraformv1.S3LogSink):
+		bucket := os.Getenv("SCIPIAN_LOG_BUCKET")
+		if bucket == "" {
+			bucket = os.Getenv("SCIPIAN_STATE_BUCKET")
+		}
+		if bucket == "" {
+			return nil, fmt.Errorf("Error: Env variable SCIPIAN_LOG_BUCKET or SCIPIAN_STATE_BUCKET not set")
+		}
+		return &S3Sink{
+			Bucket:     bucket,
+			Endpoint:   os.Getenv("SCIPIAN_S3_ENDPOINT"),
+			AccessKey:  accessKey,
+			SecretKey:  secretKey,
+			RoleARN:    os.Getenv("SCIPIAN_STATE_ROLE_ARN"),
+			ExternalID: os.Getenv("SCIPIAN_STATE_EXTERNAL_ID"),
+		}, nil
+	case "none":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("Error: unknown SCIPIAN_LOG_SINK %q", sink)
+	}
+}
+
+// ChunkLogs splits logs into chunks of at most size bytes, breaking after a newline where possible and never inside
+// a UTF-8 character
+func ChunkLogs(logs string, size int) []string {
+	if logs == "" {
+		return []string{""}
+	}
+	var chunks []string
+	for len(logs) > size {
+		end := strings.LastIndex(logs[:size], "\n") + 1
+		if end == 0 {
+			end = size
+			for end > 0 && !utf8.RuneStart(logs[end]) {
+				end--
+			}
+			// Chunks smaller than a character have to split it
+			if end == 0 {
+				end = size
+			}
+		}
+		chunks = append(chunks, logs[:end])
+		logs = logs[end:]
+	}
+	return append(chunks, logs)
+}
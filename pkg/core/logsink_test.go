@@ -0,0 +1,155 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("LogSink", func() {
+	run := &terraformv1.Run{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-run", Namespace: "default", UID: "test-uid"},
+		Spec:       terraformv1.RunSpec{WorkspaceName: "test-ws"},
+	}
+	logs := "Initializing the backend...\nApply complete! Resources: 1 added, 0 changed, 0 destroyed.\n"
+
+	Context("ChunkLogs", func() {
+		It("breaks after newlines", func() {
+			Expect(ChunkLogs("one\ntwo\nthree\n", 9)).To(Equal([]string{"one\ntwo\n", "three\n"}))
+		})
+
+		It("never splits a character when a line is too long", func() {
+			chunks := ChunkLogs("ab€cd", 4)
+			Expect(chunks).To(Equal([]string{"ab", "€c", "d"}))
+		})
+
+		It("keeps empty logs in a single chunk", func() {
+			Expect(ChunkLogs("", 4)).To(Equal([]string{""}))
+		})
+	})
+
+	Context("ConfigMapSink", func() {
+		It("stores the chunks in ConfigMaps owned by the run", func() {
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			Expect(terraformv1.AddToScheme(scheme)).To(Succeed())
+			c := fake.NewFakeClientWithScheme(scheme)
+			sink := &ConfigMapSink{Client: c, Scheme: scheme, ChunkSize: 64}
+
+			ref, err := sink.Store(run, "test-run-abcde", logs)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ref).To(Equal(terraformv1.LogReference{
+				Pod:      "test-run-abcde",
+				Sink:     terraformv1.ConfigMapLogSink,
+				Location: "test-run-abcde-log",
+				Chunks:   2,
+			}))
+
+			var stored []string
+			for _, name := range []string{"test-run-abcde-log-0", "test-run-abcde-log-1"} {
+				configMap := &corev1.ConfigMap{}
+				Expect(c.Get(context.Background(), types.NamespacedName{Name: name, Namespace: "default"}, configMap)).To(Succeed())
+				Expect(configMap.Labels[RunLabel]).To(Equal("test-run"))
+				Expect(metav1.GetControllerOf(configMap).Name).To(Equal("test-run"))
+				stored = append(stored, configMap.Data[LogKey])
+			}
+			Expect(strings.Join(stored, "")).To(Equal(logs))
+		})
+	})
+
+	Context("DirectorySink", func() {
+		It("writes the log under the namespace and run", func() {
+			dir, err := ioutil.TempDir("", "logs")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			ref, err := (&DirectorySink{Dir: dir}).Store(run, "test-run-abcde", logs)
+			Expect(err).NotTo(HaveOccurred())
+			path := filepath.Join(dir, "default", "test-run", "test-run-abcde.log")
+			Expect(ref).To(Equal(terraformv1.LogReference{Pod: "test-run-abcde", Sink: terraformv1.DirectoryLogSink, Location: path}))
+
+			contents, err := ioutil.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(Equal(logs))
+		})
+	})
+
+	Context("S3Sink", func() {
+		It("uploads the log next to the workspace state through a custom endpoint", func() {
+			var uploadedPath, uploaded string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				uploadedPath, uploaded = r.URL.Path, string(body)
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			sink := &S3Sink{Bucket: "test-bucket", Endpoint: server.URL, AccessKey: "a", SecretKey: "b"}
+			ref, err := sink.Store(run, "test-run-abcde", logs)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ref.Location).To(Equal("s3://test-bucket/default/test-ws/logs/test-run/test-run-abcde.log"))
+			Expect(uploadedPath).To(Equal("/test-bucket/default/test-ws/logs/test-run/test-run-abcde.log"))
+			Expect(uploaded).To(Equal(logs))
+		})
+	})
+
+	Context("LogSinkFromEnv", func() {
+		AfterEach(func() {
+			os.Unsetenv("SCIPIAN_LOG_SINK")
+			os.Unsetenv("SCIPIAN_LOG_DIR")
+		})
+
+		It("defaults to ConfigMaps", func() {
+			sink, err := LogSinkFromEnv(nil, nil, "", "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sink).To(BeAssignableToTypeOf(&ConfigMapSink{}))
+		})
+
+		It("needs a directory for the directory sink", func() {
+			os.Setenv("SCIPIAN_LOG_SINK", "directory")
+			_, err := LogSinkFromEnv(nil, nil, "", "")
+			Expect(err).To(HaveOccurred())
+
+			os.Setenv("SCIPIAN_LOG_DIR", "/var/log/scipian")
+			sink, err := LogSinkFromEnv(nil, nil, "", "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sink).To(Equal(&DirectorySink{Dir: "/var/log/scipian"}))
+		})
+
+		It("can be turned off", func() {
+			os.Setenv("SCIPIAN_LOG_SINK", "none")
+			sink, err := LogSinkFromEnv(nil, nil, "", "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sink).To(BeNil())
+		})
+	})
+})
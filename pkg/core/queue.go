@@ -0,0 +1,131 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+)
+
+// DefaultApprovalTimeout is how long runs wait for approval unless SCIPIAN_RUN_APPROVAL_TIMEOUT_SECONDS is set
+const DefaultApprovalTimeout = 24 * time.Hour
+
+// RunFinished reports whether a run has reached a terminal phase and no longer holds its workspace
+func RunFinished(run *terraformv1.Run) bool {
+	if run.DeletionTimestamp != nil {
+		return true
+	}
+	switch run.Status.Phase {
+	case terraformv1.ObjSucceeded, terraformv1.ObjFailed, terraformv1.ObjIncomplete, terraformv1.RunCancelled:
+		return true
+	}
+	return false
+}
+
+// RunStarted reports whether a run has left the queue. Runs that have started keep their workspace until they
+// finish, even if a run created earlier shows up later.
+func RunStarted(run *terraformv1.Run) bool {
+	switch run.Status.Phase {
+	case "", terraformv1.RunQueued:
+		return false
+	case terraformv1.ObjPending:
+		return run.Status.Reason != terraformv1.PendingJobCreation
+	}
+	return true
+}
+
+// RunInProgress reports whether a run against a workspace has not finished yet, whether it is still queued or has
+// been dequeued, as the queue counts it ahead of anything else that takes the state lock of the workspace
+func RunInProgress(workspaceName string, runs []terraformv1.Run) bool {
+	for _, run := range runs {
+		if run.Spec.WorkspaceName == workspaceName && !RunFinished(&run) {
+			return true
+		}
+	}
+	return false
+}
+
+// QueuePosition returns the number of unfinished runs against the same workspace that must finish before the given
+// run may start. Started runs come first, followed by waiting runs in order of creation.
+func QueuePosition(run *terraformv1.Run, runs []terraformv1.Run) int {
+	if RunFinished(run) || RunStarted(run) {
+		return 0
+	}
+
+	var queue []terraformv1.Run
+	for _, other := range runs {
+		if other.Spec.WorkspaceName == run.Spec.WorkspaceName && !RunFinished(&other) {
+			queue = append(queue, other)
+		}
+	}
+	sort.SliceStable(queue, func(i, j int) bool {
+		if RunStarted(&queue[i]) != RunStarted(&queue[j]) {
+			return RunStarted(&queue[i])
+		}
+		if !queue[i].CreationTimestamp.Equal(&queue[j].CreationTimestamp) {
+			return queue[i].CreationTimestamp.Before(&queue[j].CreationTimestamp)
+		}
+		return queue[i].Name < queue[j].Name
+	})
+
+	for i, other := range queue {
+		if other.Name == run.Name {
+			return i
+		}
+	}
+	// The run is not in the list yet, so it goes to the back of the queue
+	return len(queue)
+}
+
+// ApprovalTimeoutFromEnv reads how long runs wait for approval from SCIPIAN_RUN_APPROVAL_TIMEOUT_SECONDS, which
+// defaults to DefaultApprovalTimeout. Nil, set with 0, waits forever.
+func ApprovalTimeoutFromEnv() (*time.Duration, error) {
+	timeout := DefaultApprovalTimeout
+	if value, set := os.LookupEnv("SCIPIAN_RUN_APPROVAL_TIMEOUT_SECONDS"); set && value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			return nil, fmt.Errorf("Error: invalid SCIPIAN_RUN_APPROVAL_TIMEOUT_SECONDS %q", value)
+		}
+		if seconds == 0 {
+			return nil, nil
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+	return &timeout, nil
+}
+
+// ApprovalDeadline returns when a run waiting for approval is cancelled, and false if it waits forever. The
+// approvalTimeoutSeconds of the run take precedence over the timeout of the controller.
+func ApprovalDeadline(run *terraformv1.Run, timeout *time.Duration) (time.Time, bool) {
+	if run.Spec.ApprovalTimeoutSeconds != nil {
+		seconds := time.Duration(*run.Spec.ApprovalTimeoutSeconds) * time.Second
+		timeout = &seconds
+	}
+	if timeout == nil {
+		return time.Time{}, false
+	}
+	// Runs that started waiting before the time was recorded count from their creation
+	waiting := run.CreationTimestamp.Time
+	if run.Status.AwaitingApprovalTime != nil {
+		waiting = run.Status.AwaitingApprovalTime.Time
+	}
+	return waiting.Add(*timeout), true
+}
@@ -0,0 +1,154 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func queuedRun(name, workspace string, created time.Time, phase terraformv1.ObjectPhase, reason string) terraformv1.Run {
+	return terraformv1.Run{
+		ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(created)},
+		Spec:       terraformv1.RunSpec{WorkspaceName: workspace},
+		Status:     terraformv1.RunStatus{Phase: phase, Reason: reason},
+	}
+}
+
+var _ = Describe("Queue", func() {
+	now := time.Now().Truncate(time.Second)
+
+	Context("QueuePosition", func() {
+		It("orders waiting runs by creation time", func() {
+			runs := []terraformv1.Run{
+				queuedRun("third", "ws", now, "", ""),
+				queuedRun("first", "ws", now.Add(-2*time.Minute), terraformv1.ObjPending, terraformv1.PendingJobCreation),
+				queuedRun("second", "ws", now.Add(-time.Minute), terraformv1.RunQueued, terraformv1.WaitingForRun),
+			}
+
+			Expect(QueuePosition(&runs[1], runs)).To(Equal(0))
+			Expect(QueuePosition(&runs[2], runs)).To(Equal(1))
+			Expect(QueuePosition(&runs[0], runs)).To(Equal(2))
+		})
+
+		It("ignores finished runs and runs against other workspaces", func() {
+			runs := []terraformv1.Run{
+				queuedRun("done", "ws", now.Add(-3*time.Minute), terraformv1.ObjSucceeded, terraformv1.RunSucceeded),
+				queuedRun("failed", "ws", now.Add(-2*time.Minute), terraformv1.ObjFailed, terraformv1.JobFailed),
+				queuedRun("cancelled", "ws", now.Add(-2*time.Minute), terraformv1.RunCancelled, terraformv1.CancelCompleted),
+				queuedRun("other", "other-ws", now.Add(-time.Minute), terraformv1.ObjRunning, terraformv1.PodRunning),
+				queuedRun("next", "ws", now, "", ""),
+			}
+
+			Expect(QueuePosition(&runs[3], runs)).To(Equal(0))
+		})
+
+		It("keeps waiting runs behind started runs created after them", func() {
+			runs := []terraformv1.Run{
+				queuedRun("b", "ws", now, terraformv1.ObjRunning, terraformv1.PodRunning),
+				queuedRun("a", "ws", now, terraformv1.RunQueued, terraformv1.WaitingForRun),
+			}
+
+			Expect(QueuePosition(&runs[0], runs)).To(Equal(0))
+			Expect(QueuePosition(&runs[1], runs)).To(Equal(1))
+		})
+
+		It("holds the queue while a run waits for approval", func() {
+			runs := []terraformv1.Run{
+				queuedRun("gated", "ws", now.Add(-time.Minute), terraformv1.RunAwaitingApproval, terraformv1.PlanCompleted),
+				queuedRun("next", "ws", now, "", ""),
+			}
+
+			Expect(QueuePosition(&runs[1], runs)).To(Equal(1))
+		})
+	})
+	Context("RunInProgress", func() {
+		It("reports unfinished runs against the workspace", func() {
+			runs := []terraformv1.Run{
+				queuedRun("done", "ws", now.Add(-2*time.Minute), terraformv1.ObjSucceeded, terraformv1.RunSucceeded),
+				queuedRun("other", "other-ws", now.Add(-time.Minute), terraformv1.ObjRunning, terraformv1.PodRunning),
+			}
+			Expect(RunInProgress("ws", runs)).To(BeFalse())
+
+			// Dequeued runs without a job yet, and runs still waiting, hold the workspace too
+			for _, run := range []terraformv1.Run{
+				queuedRun("next", "ws", now, terraformv1.ObjPending, terraformv1.PendingJobCreation),
+				queuedRun("queued", "ws", now, terraformv1.RunQueued, terraformv1.WaitingForRun),
+				queuedRun("new", "ws", now, "", ""),
+				queuedRun("gated", "ws", now, terraformv1.RunAwaitingApproval, terraformv1.PlanCompleted),
+			} {
+				Expect(RunInProgress("ws", append(runs, run))).To(BeTrue(), run.Name)
+			}
+		})
+	})
+
+	Context("ApprovalDeadline", func() {
+		var saved string
+		var set bool
+
+		BeforeEach(func() {
+			saved, set = os.LookupEnv("SCIPIAN_RUN_APPROVAL_TIMEOUT_SECONDS")
+			os.Unsetenv("SCIPIAN_RUN_APPROVAL_TIMEOUT_SECONDS")
+		})
+
+		AfterEach(func() {
+			os.Unsetenv("SCIPIAN_RUN_APPROVAL_TIMEOUT_SECONDS")
+			if set {
+				os.Setenv("SCIPIAN_RUN_APPROVAL_TIMEOUT_SECONDS", saved)
+			}
+		})
+
+		It("cancels unapproved runs after the timeout of the controller", func() {
+			run := queuedRun("gated", "ws", now.Add(-time.Hour), terraformv1.RunAwaitingApproval, terraformv1.PlanCompleted)
+			run.Status.AwaitingApprovalTime = &metav1.Time{Time: now}
+
+			timeout, err := ApprovalTimeoutFromEnv()
+			Expect(err).NotTo(HaveOccurred())
+			deadline, expires := ApprovalDeadline(&run, timeout)
+			Expect(expires).To(BeTrue())
+			Expect(deadline).To(Equal(now.Add(DefaultApprovalTimeout)))
+
+			os.Setenv("SCIPIAN_RUN_APPROVAL_TIMEOUT_SECONDS", "600")
+			timeout, err = ApprovalTimeoutFromEnv()
+			Expect(err).NotTo(HaveOccurred())
+			deadline, _ = ApprovalDeadline(&run, timeout)
+			Expect(deadline).To(Equal(now.Add(10 * time.Minute)))
+
+			seconds := int32(60)
+			run.Spec.ApprovalTimeoutSeconds = &seconds
+			deadline, _ = ApprovalDeadline(&run, timeout)
+			Expect(deadline).To(Equal(now.Add(time.Minute)))
+		})
+
+		It("lets runs wait forever without a timeout", func() {
+			os.Setenv("SCIPIAN_RUN_APPROVAL_TIMEOUT_SECONDS", "0")
+			timeout, err := ApprovalTimeoutFromEnv()
+			Expect(err).NotTo(HaveOccurred())
+			run := queuedRun("gated", "ws", now, terraformv1.RunAwaitingApproval, terraformv1.PlanCompleted)
+			_, expires := ApprovalDeadline(&run, timeout)
+			Expect(expires).To(BeFalse())
+
+			os.Setenv("SCIPIAN_RUN_APPROVAL_TIMEOUT_SECONDS", "soon")
+			_, err = ApprovalTimeoutFromEnv()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
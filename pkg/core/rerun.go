@@ -0,0 +1,68 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+)
+
+const (
+	// RerunAnnotation starts a new attempt of a Run whenever its value changes
+	RerunAnnotation = "terraform.scipian.io/rerun"
+
+	// maxPreviousAttempts is how many earlier attempts are kept in the status of a Run
+	maxPreviousAttempts = 10
+)
+
+// RerunRequested reports whether a run was edited or asked to rerun since its current attempt started
+func RerunRequested(run *terraformv1.Run) bool {
+	// Runs created before attempts were tracked start tracking from their current spec
+	if run.Status.SpecHash == "" {
+		return false
+	}
+	return run.Status.SpecHash != RunSpecHash(run.Spec) || run.Status.Rerun != run.Annotations[RerunAnnotation]
+}
+
+// StartAttempt records the outcome of the current attempt of a run, and resets its status for a new attempt of its
+// current spec. The first attempt has nothing to record.
+func StartAttempt(run *terraformv1.Run) {
+	status := &run.Status
+	if status.Attempt > 0 {
+		status.PreviousAttempts = append(status.PreviousAttempts, terraformv1.RunAttempt{
+			Attempt:        status.Attempt,
+			Phase:          status.Phase,
+			Reason:         status.Reason,
+			CompletionTime: status.CompletionTime,
+			PlanSummary:    status.PlanSummary,
+			Logs:           status.Logs,
+			SourceRevision: status.SourceRevision,
+		})
+		if len(status.PreviousAttempts) > maxPreviousAttempts {
+			status.PreviousAttempts = status.PreviousAttempts[len(status.PreviousAttempts)-maxPreviousAttempts:]
+		}
+		status.JobCompleted = false
+		status.PlanCompleted = false
+		status.PlanSummary = nil
+		status.QueuePosition = 0
+		status.CompletionTime = nil
+		status.AwaitingApprovalTime = nil
+		status.Logs = nil
+		status.SourceRevision = ""
+	}
+	status.Attempt++
+	status.SpecHash = RunSpecHash(run.Spec)
+	status.Rerun = run.Annotations[RerunAnnotation]
+}
@@ -0,0 +1,118 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Rerun", func() {
+	newRun := func() *terraformv1.Run {
+		return &terraformv1.Run{
+			ObjectMeta: metav1.ObjectMeta{Name: "run", Annotations: map[string]string{}},
+			Spec: terraformv1.RunSpec{
+				WorkspaceName: "ws",
+				Mode:          terraformv1.RunModeApproval,
+				Targets:       []string{"aws_instance.web"},
+			},
+		}
+	}
+
+	Context("RunSpecHash", func() {
+		It("ignores approving and cancelling", func() {
+			run := newRun()
+			hash := RunSpecHash(run.Spec)
+			run.Spec.Approved = true
+			run.Spec.Cancel = true
+			Expect(RunSpecHash(run.Spec)).To(Equal(hash))
+		})
+
+		It("changes with what the run executes", func() {
+			run := newRun()
+			hash := RunSpecHash(run.Spec)
+			run.Spec.Targets = append(run.Spec.Targets, "aws_instance.db")
+			Expect(RunSpecHash(run.Spec)).NotTo(Equal(hash))
+		})
+	})
+
+	Context("RerunRequested", func() {
+		It("does not rerun runs that have not started an attempt", func() {
+			Expect(RerunRequested(newRun())).To(BeFalse())
+		})
+
+		It("reruns edited runs and runs with a new rerun annotation", func() {
+			run := newRun()
+			StartAttempt(run)
+			Expect(RerunRequested(run)).To(BeFalse())
+
+			run.Annotations[RerunAnnotation] = "1"
+			Expect(RerunRequested(run)).To(BeTrue())
+			StartAttempt(run)
+			Expect(RerunRequested(run)).To(BeFalse())
+
+			run.Spec.Type = terraformv1.RunTypeRefreshOnly
+			Expect(RerunRequested(run)).To(BeTrue())
+		})
+	})
+
+	Context("StartAttempt", func() {
+		It("records the outcome of the previous attempt and resets the status", func() {
+			run := newRun()
+			StartAttempt(run)
+			Expect(run.Status.Attempt).To(Equal(1))
+			Expect(run.Status.PreviousAttempts).To(BeEmpty())
+
+			completed := metav1.Now()
+			run.Status.Phase = terraformv1.ObjFailed
+			run.Status.Reason = terraformv1.JobFailed
+			run.Status.JobCompleted = true
+			run.Status.PlanCompleted = true
+			run.Status.CompletionTime = &completed
+			run.Status.Logs = []terraformv1.LogReference{{Pod: "run-abcde", Sink: terraformv1.ConfigMapLogSink, Location: "run-abcde-log", Chunks: 1}}
+			run.Status.SourceRevision = "3f2c1e0"
+			run.Annotations[RerunAnnotation] = "retry"
+			StartAttempt(run)
+
+			Expect(run.Status.Attempt).To(Equal(2))
+			Expect(run.Status.Rerun).To(Equal("retry"))
+			Expect(run.Status.JobCompleted).To(BeFalse())
+			Expect(run.Status.PlanCompleted).To(BeFalse())
+			Expect(run.Status.CompletionTime).To(BeNil())
+			Expect(run.Status.Logs).To(BeNil())
+			Expect(run.Status.SourceRevision).To(BeEmpty())
+			Expect(run.Status.PreviousAttempts).To(Equal([]terraformv1.RunAttempt{{
+				Attempt:        1,
+				Phase:          terraformv1.ObjFailed,
+				Reason:         terraformv1.JobFailed,
+				CompletionTime: &completed,
+				Logs:           []terraformv1.LogReference{{Pod: "run-abcde", Sink: terraformv1.ConfigMapLogSink, Location: "run-abcde-log", Chunks: 1}},
+				SourceRevision: "3f2c1e0",
+			}}))
+		})
+
+		It("keeps a bounded number of previous attempts", func() {
+			run := newRun()
+			for i := 0; i < maxPreviousAttempts+5; i++ {
+				StartAttempt(run)
+			}
+			Expect(run.Status.PreviousAttempts).To(HaveLen(maxPreviousAttempts))
+			Expect(run.Status.PreviousAttempts[0].Attempt).To(Equal(5))
+		})
+	})
+})
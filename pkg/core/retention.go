@@ -0,0 +1,98 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+)
+
+// RetentionPolicy decides how long finished Runs are kept before they are deleted along with the objects they own
+type RetentionPolicy struct {
+	// TTL is how long finished runs without a ttlSecondsAfterFinished of their own are kept. Nil keeps them forever.
+	TTL *time.Duration
+	// HistoryLimit is how many finished runs are kept per workspace. Nil keeps all of them.
+	HistoryLimit *int
+}
+
+// RetentionPolicyFromEnv reads the controller-wide retention policy from SCIPIAN_RUN_TTL_SECONDS and
+// SCIPIAN_RUN_HISTORY_LIMIT
+func RetentionPolicyFromEnv() (RetentionPolicy, error) {
+	policy := RetentionPolicy{}
+	if value, set := os.LookupEnv("SCIPIAN_RUN_TTL_SECONDS"); set && value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			return policy, fmt.Errorf("Error: invalid SCIPIAN_RUN_TTL_SECONDS %q", value)
+		}
+		ttl := time.Duration(seconds) * time.Second
+		policy.TTL = &ttl
+	}
+	if value, set := os.LookupEnv("SCIPIAN_RUN_HISTORY_LIMIT"); set && value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return policy, fmt.Errorf("Error: invalid SCIPIAN_RUN_HISTORY_LIMIT %q", value)
+		}
+		policy.HistoryLimit = &limit
+	}
+	return policy, nil
+}
+
+// RunExpiry returns when a finished run expires, and false if it never does
+func (p RetentionPolicy) RunExpiry(run *terraformv1.Run) (time.Time, bool) {
+	var ttl time.Duration
+	switch {
+	case run.Spec.TTLSecondsAfterFinished != nil:
+		ttl = time.Duration(*run.Spec.TTLSecondsAfterFinished) * time.Second
+	case p.TTL != nil:
+		ttl = *p.TTL
+	default:
+		return time.Time{}, false
+	}
+	// Runs that finished before completion times were recorded count from their creation
+	finished := run.CreationTimestamp.Time
+	if run.Status.CompletionTime != nil {
+		finished = run.Status.CompletionTime.Time
+	}
+	return finished.Add(ttl), true
+}
+
+// ExcessRuns returns the finished runs against a workspace that are older than the HistoryLimit most recent ones
+func (p RetentionPolicy) ExcessRuns(runs []terraformv1.Run) []terraformv1.Run {
+	if p.HistoryLimit == nil {
+		return nil
+	}
+	var finished []terraformv1.Run
+	for _, run := range runs {
+		if RunFinished(&run) && run.DeletionTimestamp == nil {
+			finished = append(finished, run)
+		}
+	}
+	if len(finished) <= *p.HistoryLimit {
+		return nil
+	}
+	sort.SliceStable(finished, func(i, j int) bool {
+		if !finished[i].CreationTimestamp.Equal(&finished[j].CreationTimestamp) {
+			return finished[j].CreationTimestamp.Before(&finished[i].CreationTimestamp)
+		}
+		return finished[i].Name > finished[j].Name
+	})
+	return finished[*p.HistoryLimit:]
+}
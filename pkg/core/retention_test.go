@@ -0,0 +1,117 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Retention", func() {
+	now := time.Now().Truncate(time.Second)
+
+	Context("RetentionPolicyFromEnv", func() {
+		AfterEach(func() {
+			os.Unsetenv("SCIPIAN_RUN_TTL_SECONDS")
+			os.Unsetenv("SCIPIAN_RUN_HISTORY_LIMIT")
+		})
+
+		It("keeps runs forever by default", func() {
+			policy, err := RetentionPolicyFromEnv()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(policy.TTL).To(BeNil())
+			Expect(policy.HistoryLimit).To(BeNil())
+		})
+
+		It("reads the TTL and history limit", func() {
+			os.Setenv("SCIPIAN_RUN_TTL_SECONDS", "3600")
+			os.Setenv("SCIPIAN_RUN_HISTORY_LIMIT", "5")
+			policy, err := RetentionPolicyFromEnv()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*policy.TTL).To(Equal(time.Hour))
+			Expect(*policy.HistoryLimit).To(Equal(5))
+		})
+
+		It("rejects invalid values", func() {
+			os.Setenv("SCIPIAN_RUN_HISTORY_LIMIT", "-1")
+			_, err := RetentionPolicyFromEnv()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("RunExpiry", func() {
+		ttl := time.Hour
+		policy := RetentionPolicy{TTL: &ttl}
+		completed := metav1.NewTime(now)
+
+		It("prefers the TTL of the run", func() {
+			seconds := int32(60)
+			run := queuedRun("run", "ws", now.Add(-time.Hour), terraformv1.ObjSucceeded, terraformv1.RunSucceeded)
+			run.Spec.TTLSecondsAfterFinished = &seconds
+			run.Status.CompletionTime = &completed
+
+			expiry, expires := policy.RunExpiry(&run)
+			Expect(expires).To(BeTrue())
+			Expect(expiry).To(Equal(now.Add(time.Minute)))
+		})
+
+		It("falls back to the controller-wide TTL and the creation time", func() {
+			run := queuedRun("run", "ws", now.Add(-time.Hour), terraformv1.ObjFailed, terraformv1.JobFailed)
+
+			expiry, expires := policy.RunExpiry(&run)
+			Expect(expires).To(BeTrue())
+			Expect(expiry).To(Equal(now))
+		})
+
+		It("never expires runs without a TTL", func() {
+			run := queuedRun("run", "ws", now, terraformv1.ObjSucceeded, terraformv1.RunSucceeded)
+
+			_, expires := RetentionPolicy{}.RunExpiry(&run)
+			Expect(expires).To(BeFalse())
+		})
+	})
+
+	Context("ExcessRuns", func() {
+		It("returns the oldest finished runs beyond the history limit", func() {
+			limit := 2
+			runs := []terraformv1.Run{
+				queuedRun("oldest", "ws", now.Add(-4*time.Minute), terraformv1.ObjSucceeded, terraformv1.RunSucceeded),
+				queuedRun("newest", "ws", now, terraformv1.ObjSucceeded, terraformv1.RunSucceeded),
+				queuedRun("old", "ws", now.Add(-3*time.Minute), terraformv1.ObjFailed, terraformv1.JobFailed),
+				queuedRun("new", "ws", now.Add(-2*time.Minute), terraformv1.ObjIncomplete, terraformv1.ErrRetriveTfstate),
+				queuedRun("running", "ws", now.Add(-5*time.Minute), terraformv1.ObjRunning, terraformv1.PodRunning),
+			}
+
+			excess := RetentionPolicy{HistoryLimit: &limit}.ExcessRuns(runs)
+			Expect(excess).To(HaveLen(2))
+			Expect(excess[0].Name).To(Equal("old"))
+			Expect(excess[1].Name).To(Equal("oldest"))
+		})
+
+		It("keeps every run without a history limit", func() {
+			runs := []terraformv1.Run{
+				queuedRun("done", "ws", now, terraformv1.ObjSucceeded, terraformv1.RunSucceeded),
+			}
+
+			Expect(RetentionPolicy{}.ExcessRuns(runs)).To(BeEmpty())
+		})
+	})
+})
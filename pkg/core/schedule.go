@@ -0,0 +1,50 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// maxMissedSchedules is the number of missed start times after which a schedule is considered broken, e.g. when the
+// clock was skewed or the controller was down for a long time
+const maxMissedSchedules = 100
+
+// MissedSchedule returns the most recent time the cron schedule was due after earliest and up to now, or the zero
+// time if it was not due, together with the next time it is due after now
+func MissedSchedule(schedule string, earliest time.Time, now time.Time) (time.Time, time.Time, error) {
+	var lastMissed time.Time
+	sched, err := cron.ParseStandard(schedule)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("unparseable schedule %q: %v", schedule, err)
+	}
+	if earliest.After(now) {
+		return lastMissed, sched.Next(now), nil
+	}
+
+	starts := 0
+	for t := sched.Next(earliest); !t.After(now); t = sched.Next(t) {
+		lastMissed = t
+		starts++
+		if starts > maxMissedSchedules {
+			return time.Time{}, time.Time{}, fmt.Errorf("too many missed start times (> %d)", maxMissedSchedules)
+		}
+	}
+	return lastMissed, sched.Next(now), nil
+}
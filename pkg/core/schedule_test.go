@@ -0,0 +1,57 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Schedule", func() {
+	now := time.Date(2020, time.March, 10, 12, 30, 0, 0, time.UTC)
+
+	Context("MissedSchedule", func() {
+		It("returns the most recent missed start time and the next one", func() {
+			missed, next, err := MissedSchedule("0 * * * *", now.Add(-3*time.Hour), now)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(missed).To(Equal(time.Date(2020, time.March, 10, 12, 0, 0, 0, time.UTC)))
+			Expect(next).To(Equal(time.Date(2020, time.March, 10, 13, 0, 0, 0, time.UTC)))
+		})
+
+		It("returns the zero time if nothing was missed", func() {
+			missed, next, err := MissedSchedule("@daily", now.Add(-time.Hour), now)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(missed.IsZero()).To(BeTrue())
+			Expect(next).To(Equal(time.Date(2020, time.March, 11, 0, 0, 0, 0, time.UTC)))
+		})
+
+		It("returns an error for an invalid schedule", func() {
+			_, _, err := MissedSchedule("every day", now, now)
+
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("returns an error if too many start times were missed", func() {
+			_, _, err := MissedSchedule("* * * * *", now.Add(-24*time.Hour), now)
+
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,89 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// DefaultStateHistoryLimit is how many versions of the state of each workspace are listed unless
+// SCIPIAN_STATE_HISTORY_LIMIT is set
+const DefaultStateHistoryLimit = 10
+
+// unversionedID is the version ID S3 lists the objects of buckets without versioning under
+const unversionedID = "null"
+
+// StateHistoryLimit returns how many versions of the state of each workspace are listed in its status, as set by
+// SCIPIAN_STATE_HISTORY_LIMIT. Zero keeps no history.
+func StateHistoryLimit() (int, error) {
+	value := os.Getenv("SCIPIAN_STATE_HISTORY_LIMIT")
+	if value == "" {
+		return DefaultStateHistoryLimit, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 {
+		return 0, fmt.Errorf("Error: invalid SCIPIAN_STATE_HISTORY_LIMIT %q", value)
+	}
+	return limit, nil
+}
+
+// BackendVersions returns the versions of the state of a workspace kept by its backend, newest first, or nil if the
+// backend keeps no earlier versions and the controller has to keep snapshots instead. Only S3 buckets with
+// versioning enabled keep them.
+func BackendVersions(store StateStore, workspace types.NamespacedName) ([]StateVersion, error) {
+	s3Store, ok := store.(*S3StateStore)
+	if !ok {
+		return nil, nil
+	}
+	versions, err := s3Store.Versions(workspace)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 || versions[0].ID == unversionedID {
+		return nil, nil
+	}
+	return versions, nil
+}
+
+// FindStateVersion returns the version of the state history of a workspace with the given ID
+func FindStateVersion(workspace *terraformv1.Workspace, id string) (*terraformv1.StateVersion, bool) {
+	for i := range workspace.Status.StateHistory {
+		if workspace.Status.StateHistory[i].ID == id {
+			return &workspace.Status.StateHistory[i], true
+		}
+	}
+	return nil, false
+}
+
+// AddSnapshot puts a snapshot at the front of a state history, replacing an earlier snapshot of the same state, and
+// cuts the history down to limit versions
+func AddSnapshot(history []terraformv1.StateVersion, snapshot terraformv1.StateVersion, limit int) []terraformv1.StateVersion {
+	kept := []terraformv1.StateVersion{snapshot}
+	for _, version := range history {
+		if version.ID != snapshot.ID {
+			kept = append(kept, version)
+		}
+	}
+	if len(kept) > limit {
+		kept = kept[:limit]
+	}
+	return kept
+}
@@ -0,0 +1,110 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("State history", func() {
+	workspace := types.NamespacedName{Namespace: "team-a", Name: "vpc"}
+
+	Context("StateHistoryLimit", func() {
+		AfterEach(func() {
+			os.Unsetenv("SCIPIAN_STATE_HISTORY_LIMIT")
+		})
+
+		It("reads the limit of the controller", func() {
+			Expect(StateHistoryLimit()).To(Equal(DefaultStateHistoryLimit))
+			os.Setenv("SCIPIAN_STATE_HISTORY_LIMIT", "0")
+			Expect(StateHistoryLimit()).To(Equal(0))
+			os.Setenv("SCIPIAN_STATE_HISTORY_LIMIT", "-1")
+			_, err := StateHistoryLimit()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("BackendVersions", func() {
+		It("lists the versions of a versioned bucket", func() {
+			server := fakeS3("state", map[string][]string{
+				"team-a/vpc/terraform.tfstate": {`{"serial": 1}`, `{"serial": 2}`},
+			})
+			defer server.Close()
+			store := &S3StateStore{Bucket: "state", Region: "us-west-2", Endpoint: server.URL, AccessKey: "a", SecretKey: "b"}
+
+			versions, err := BackendVersions(store, workspace)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(versions).To(HaveLen(2))
+			Expect(versions[0].ID).To(Equal("v2"))
+		})
+
+		It("leaves keeping versions to the controller for other stores", func() {
+			store := &MemoryStateStore{}
+			store.Put(workspace, `{"serial": 1}`)
+			store.Put(workspace, `{"serial": 2}`)
+			Expect(BackendVersions(store, workspace)).To(BeNil())
+			Expect(BackendVersions(nil, workspace)).To(BeNil())
+		})
+	})
+
+	Context("Snapshots", func() {
+		snapshot := func(id string, serial int64) terraformv1.StateVersion {
+			return terraformv1.StateVersion{ID: id, Serial: serial, Snapshot: &terraformv1.StateReference{SecretName: "vpc-tfstate-" + id}}
+		}
+
+		It("keeps the most recent snapshots", func() {
+			history := AddSnapshot(nil, snapshot("a", 1), 2)
+			history = AddSnapshot(history, snapshot("b", 2), 2)
+			history = AddSnapshot(history, snapshot("c", 3), 2)
+			Expect(history).To(Equal([]terraformv1.StateVersion{snapshot("c", 3), snapshot("b", 2)}))
+		})
+
+		It("moves a snapshot of a restored state to the front", func() {
+			history := []terraformv1.StateVersion{snapshot("b", 2), snapshot("a", 1)}
+			Expect(AddSnapshot(history, snapshot("a", 1), 10)).To(Equal([]terraformv1.StateVersion{snapshot("a", 1), snapshot("b", 2)}))
+		})
+
+		It("finds versions of the history", func() {
+			ws := &terraformv1.Workspace{Status: terraformv1.WorkspaceStatus{
+				StateHistory: []terraformv1.StateVersion{snapshot("b", 2), snapshot("a", 1)},
+			}}
+			version, found := FindStateVersion(ws, "a")
+			Expect(found).To(BeTrue())
+			Expect(version.Serial).To(Equal(int64(1)))
+			_, found = FindStateVersion(ws, "c")
+			Expect(found).To(BeFalse())
+		})
+
+		It("splits snapshots across Secrets of their own", func() {
+			ws := &terraformv1.Workspace{ObjectMeta: metav1.ObjectMeta{Name: "vpc", Namespace: "team-a"}}
+			state := `{"version": 4, "serial": 1}`
+			secrets, ref, err := StateSnapshotSecrets(ws, state, GzipCompression, StateChunkSize)
+			Expect(err).NotTo(HaveOccurred())
+			id := SnapshotID(state)
+			Expect(id).To(HaveLen(12))
+			Expect(ref.SecretName).To(Equal("vpc-tfstate-" + id))
+			Expect(secrets[0].Name).To(Equal("vpc-tfstate-" + id + "-0"))
+			Expect(secrets[0].Labels).To(Equal(map[string]string{WorkspaceLabel: "vpc", StateVersionLabel: id}))
+			Expect(JoinStateSecrets(ref, secrets)).To(Equal(state))
+		})
+	})
+})
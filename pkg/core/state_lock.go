@@ -0,0 +1,112 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// lockInfo is the lock holder Terraform records with each lock, in the JSON it writes to lock tables
+type lockInfo struct {
+	ID        string    `json:"ID"`
+	Operation string    `json:"Operation"`
+	Who       string    `json:"Who"`
+	Version   string    `json:"Version"`
+	Created   time.Time `json:"Created"`
+	Path      string    `json:"Path"`
+}
+
+// LockTable reads the locks s3 backends take on the state of workspaces in a DynamoDB table. Terraform keeps each
+// lock in an item whose LockID is <bucket>/<key of the state>, with the lock holder as JSON in its Info attribute.
+// RoleARN is assumed to read it, like the state, if set.
+type LockTable struct {
+	Table      string
+	Bucket     string
+	Region     string
+	Endpoint   string
+	AccessKey  string
+	SecretKey  string
+	RoleARN    string
+	ExternalID string
+}
+
+// LockTableFor returns the lock table of a resolved backend, or nil for backends that are not locked in DynamoDB.
+// The lock table is not read through the endpoint of S3-compatible stores, as Terraform does not either.
+func LockTableFor(backend *terraformv1.Backend, accessKey string, secretKey string) *LockTable {
+	if backend.S3 == nil || backend.S3.DynamoDBTable == "" {
+		return nil
+	}
+	return &LockTable{
+		Table:      backend.S3.DynamoDBTable,
+		Bucket:     backend.S3.Bucket,
+		Region:     backend.S3.Region,
+		AccessKey:  accessKey,
+		SecretKey:  secretKey,
+		RoleARN:    backend.S3.RoleARN,
+		ExternalID: backend.S3.ExternalID,
+	}
+}
+
+// LockID returns the ID of the item holding the lock on the state of a workspace
+func (t *LockTable) LockID(workspace types.NamespacedName) string {
+	return fmt.Sprintf("%s/%s/%s/%s", t.Bucket, workspace.Namespace, workspace.Name, TFStateFileName)
+}
+
+// Get returns the lock held on the state of a workspace, or nil if the state is not locked
+func (t *LockTable) Get(workspace types.NamespacedName) (*terraformv1.StateLock, error) {
+	sess, err := createNewSession(t.Region, t.Endpoint, staticCredentials(t.AccessKey, t.SecretKey), t.RoleARN, t.ExternalID)
+	if err != nil {
+		return nil, err
+	}
+	output, err := dynamodb.New(sess).GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(t.Table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"LockID": {S: aws.String(t.LockID(workspace))},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state lock: %v", err)
+	}
+	info, ok := output.Item["Info"]
+	if !ok || info.S == nil {
+		return nil, nil
+	}
+	lock := &lockInfo{}
+	if err := json.Unmarshal([]byte(aws.StringValue(info.S)), lock); err != nil {
+		return nil, fmt.Errorf("failed to parse state lock: %v", err)
+	}
+	stateLock := &terraformv1.StateLock{
+		ID:        lock.ID,
+		Path:      lock.Path,
+		Operation: lock.Operation,
+		Who:       lock.Who,
+		Version:   lock.Version,
+	}
+	if !lock.Created.IsZero() {
+		created := metav1.NewTime(lock.Created)
+		stateLock.Created = &created
+	}
+	return stateLock, nil
+}
@@ -0,0 +1,99 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// fakeDynamoDB serves the Info attribute of the items of a lock table by LockID, the way DynamoDB does
+func fakeDynamoDB(table string, items map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		input := struct {
+			TableName string
+			Key       map[string]map[string]string
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.TableName != table {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"__type": "com.amazonaws.dynamodb.v20120810#ResourceNotFoundException"}`)
+			return
+		}
+		lockID := input.Key["LockID"]["S"]
+		info, ok := items[lockID]
+		if !ok {
+			fmt.Fprint(w, `{}`)
+			return
+		}
+		item := map[string]interface{}{"Item": map[string]interface{}{
+			"LockID": map[string]string{"S": lockID},
+			"Info":   map[string]string{"S": info},
+		}}
+		_ = json.NewEncoder(w).Encode(item)
+	}))
+}
+
+var _ = Describe("LockTable", func() {
+	workspace := types.NamespacedName{Namespace: "team-a", Name: "vpc"}
+
+	It("is only used by s3 backends with a DynamoDB table", func() {
+		table := LockTableFor(&terraformv1.Backend{S3: &terraformv1.S3Backend{
+			Bucket:        "state",
+			Region:        "us-west-2",
+			Endpoint:      "https://minio.example.com",
+			DynamoDBTable: "state-locking",
+		}}, "a", "b")
+		Expect(table).To(Equal(&LockTable{Table: "state-locking", Bucket: "state", Region: "us-west-2", AccessKey: "a", SecretKey: "b"}))
+		Expect(table.LockID(workspace)).To(Equal("state/team-a/vpc/terraform.tfstate"))
+
+		Expect(LockTableFor(&terraformv1.Backend{S3: &terraformv1.S3Backend{Bucket: "state"}}, "a", "b")).To(BeNil())
+		Expect(LockTableFor(&terraformv1.Backend{Kubernetes: &terraformv1.KubernetesBackend{}}, "a", "b")).To(BeNil())
+	})
+
+	It("reads the holder of a lock", func() {
+		server := fakeDynamoDB("state-locking", map[string]string{
+			"state/team-a/vpc/terraform.tfstate": `{"ID":"5f3c2b9e-0c1d-4f7a-9a3e-2d1b4c5e6f70","Operation":"OperationTypeApply",` +
+				`"Info":"","Who":"root@vpc-run-abcde","Version":"0.12.29","Created":"2020-08-04T16:21:50.123456Z",` +
+				`"Path":"state/team-a/vpc/terraform.tfstate"}`,
+			"state/team-a/vpc/terraform.tfstate-md5": "",
+		})
+		defer server.Close()
+		table := &LockTable{Table: "state-locking", Bucket: "state", Region: "us-west-2", Endpoint: server.URL, AccessKey: "a", SecretKey: "b"}
+
+		lock, err := table.Get(workspace)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lock.ID).To(Equal("5f3c2b9e-0c1d-4f7a-9a3e-2d1b4c5e6f70"))
+		Expect(lock.Path).To(Equal("state/team-a/vpc/terraform.tfstate"))
+		Expect(lock.Operation).To(Equal("OperationTypeApply"))
+		Expect(lock.Who).To(Equal("root@vpc-run-abcde"))
+		Expect(lock.Version).To(Equal("0.12.29"))
+		Expect(lock.Created.Time.Equal(time.Date(2020, 8, 4, 16, 21, 50, 123456000, time.UTC))).To(BeTrue())
+
+		Expect(table.Get(types.NamespacedName{Namespace: "team-a", Name: "dns"})).To(BeNil())
+
+		table.Table = "missing"
+		_, err = table.Get(workspace)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -6,15 +6,17 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/s3"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	terraformv1 "github.com/scipian/terraform-controller/api/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 var _ = Describe("RetrieveState", func() {
@@ -24,56 +26,70 @@ var _ = Describe("RetrieveState", func() {
 			Namespace: "default",
 		},
 	}
-	s3Bucket := os.Getenv("SCIPIAN_STATE_BUCKET")
 	accessKey := os.Getenv("AWS_ACCESS_KEY_ID")
 	secretKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
 	workspace := &ws
 	filePath := fmt.Sprintf("%s/%s/%s", workspace.Namespace, workspace.Name, TFStateFileName)
-	directoryPath := fmt.Sprintf("%s/%s", workspace.Namespace, workspace.Name)
 
 	Describe("Retrieve tfstate", func() {
 		Context("Retrieve tfstate", func() {
-			RetrieveState(workspace, accessKey, secretKey)
 			Context("Set AWS Creds", func() {
-				It("Sets AWS creds", func() {
-					err := setAWSCreds(accessKey, secretKey)
-					Expect(err).ToNot(HaveOccurred())
-					Expect(os.Getenv("AWS_ACCESS_KEY_ID")).To(Equal(accessKey))
-					Expect(os.Getenv("AWS_SECRET_ACCESS_KEY")).To(Equal(secretKey))
-				})
-			})
-
-			Context("Pull State", func() {
-				It("Pulls tfstate from S3", func() {
-					client, err := customClientWithCertPool()
-					Expect(err).ToNot(HaveOccurred())
+				It("Signs with the given creds without setting them in the environment", func() {
+					var signedWith string
 					server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-						w.WriteHeader(http.StatusOK)
+						signedWith = strings.SplitN(r.Header.Get("Authorization"), "/", 2)[0]
 						io.WriteString(w, "Test data")
 					}))
+					defer server.Close()
 
-					sess := session.Must(session.NewSession(&aws.Config{
-						DisableSSL:       aws.Bool(true),
-						Endpoint:         aws.String(server.URL),
-						Region:           aws.String("test-region"),
-						S3ForcePathStyle: aws.Bool(true),
-						HTTPClient:       client,
-					}))
-					downloader := s3manager.NewDownloader(sess)
-					err = s3Puller(s3Bucket, filePath, downloader, directoryPath)
+					sess, err := createNewSession("test-region", server.URL, staticCredentials("tenant-key", "tenant-secret"), "", "")
 					Expect(err).ToNot(HaveOccurred())
+					_, err = s3.New(sess).GetObject(&s3.GetObjectInput{Bucket: aws.String("state"), Key: aws.String(filePath)})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(signedWith).To(Equal("AWS4-HMAC-SHA256 Credential=tenant-key"))
+					Expect(os.Getenv("AWS_ACCESS_KEY_ID")).To(Equal(accessKey))
+					Expect(os.Getenv("AWS_SECRET_ACCESS_KEY")).To(Equal(secretKey))
 				})
 
-			})
-			Context("Read state", func() {
-				It("Reads the tfstate file", func() {
-					state, err := getState(filePath)
-
-					Expect(err).ToNot(HaveOccurred())
-					Expect(state).ToNot(BeNil())
-					os.RemoveAll(directoryPath)
-					os.Remove(fmt.Sprintf("./%s", workspace.Namespace))
+				It("Retrieves state in parallel with the creds of each store", func() {
+					// Each tenant can only read the state of its own namespace with its own key
+					objects := map[string][]string{}
+					for i := 0; i < 8; i++ {
+						objects[fmt.Sprintf("tenant-%d/vpc/%s", i, TFStateFileName)] = []string{fmt.Sprintf(`{"serial": %d}`, i)}
+					}
+					handler := fakeS3Handler("state", objects)
+					server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						tenant := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/state/"), "/", 2)[0]
+						if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential="+tenant+"/") {
+							w.WriteHeader(http.StatusForbidden)
+							io.WriteString(w, `<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`)
+							return
+						}
+						handler.ServeHTTP(w, r)
+					}))
+					defer server.Close()
 
+					var wg sync.WaitGroup
+					errs := make(chan error, 8*10)
+					for i := 0; i < 8; i++ {
+						for j := 0; j < 10; j++ {
+							wg.Add(1)
+							go func(tenant string, serial int) {
+								defer wg.Done()
+								store := &S3StateStore{Bucket: "state", Region: "us-west-2", Endpoint: server.URL, AccessKey: tenant, SecretKey: tenant}
+								state, err := store.Get(types.NamespacedName{Namespace: tenant, Name: "vpc"}, "")
+								if err == nil && state != fmt.Sprintf(`{"serial": %d}`, serial) {
+									err = fmt.Errorf("%s read %s", tenant, state)
+								}
+								errs <- err
+							}(fmt.Sprintf("tenant-%d", i), i)
+						}
+					}
+					wg.Wait()
+					close(errs)
+					for err := range errs {
+						Expect(err).ToNot(HaveOccurred())
+					}
 				})
 			})
 		})
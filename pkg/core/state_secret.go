@@ -0,0 +1,169 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// StateChunkSize is the largest part of a state stored in a single Secret, well below the 1MiB Secret limit
+	StateChunkSize = 512 * 1024
+
+	// StateSecretKey is the Secret key holding a chunk of a state
+	StateSecretKey = "tfstate"
+
+	// WorkspaceLabel labels the objects stored for a Workspace with the name of the Workspace
+	WorkspaceLabel = "terraform.scipian.io/workspace"
+
+	// StateVersionLabel labels the Secrets of a snapshot of a state with the ID of the snapshot
+	StateVersionLabel = "terraform.scipian.io/state-version"
+
+	// GzipCompression compresses state with gzip before it is split
+	GzipCompression = "gzip"
+)
+
+// StateCompression returns the compression of state Secrets selected by SCIPIAN_STATE_COMPRESSION: gzip (the default)
+// or none, which is returned as ""
+func StateCompression() (string, error) {
+	switch compression := os.Getenv("SCIPIAN_STATE_COMPRESSION"); compression {
+	case "", GzipCompression:
+		return GzipCompression, nil
+	case "none":
+		return "", nil
+	default:
+		return "", fmt.Errorf("Error: unknown SCIPIAN_STATE_COMPRESSION %q", compression)
+	}
+}
+
+// StateSecretName returns the name prefix of the Secrets holding the state of a workspace
+func StateSecretName(workspace *terraformv1.Workspace) string {
+	return fmt.Sprintf("%s-tfstate", workspace.Name)
+}
+
+// StateDigest returns the SHA-256 digest of a state
+func StateDigest(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return hex.EncodeToString(sum[:])
+}
+
+// StateSecrets splits the state of a workspace, compressed if compression is gzip, across Secrets named
+// <workspace>-tfstate-0 and up, and returns them with the reference recorded in the workspace status
+func StateSecrets(workspace *terraformv1.Workspace, state string, compression string, chunkSize int) ([]*corev1.Secret, terraformv1.StateReference, error) {
+	labels := map[string]string{WorkspaceLabel: workspace.Name}
+	return splitState(workspace, StateSecretName(workspace), labels, state, compression, chunkSize)
+}
+
+// SnapshotID returns the ID of the snapshot of a state, which is the same for snapshots of the same state
+func SnapshotID(state string) string {
+	return StateDigest(state)[:12]
+}
+
+// StateSnapshotSecrets splits a snapshot of the state of a workspace across Secrets named <workspace>-tfstate-<id>-0
+// and up, the same way as StateSecrets
+func StateSnapshotSecrets(workspace *terraformv1.Workspace, state string, compression string, chunkSize int) ([]*corev1.Secret, terraformv1.StateReference, error) {
+	id := SnapshotID(state)
+	labels := map[string]string{WorkspaceLabel: workspace.Name, StateVersionLabel: id}
+	return splitState(workspace, fmt.Sprintf("%s-%s", StateSecretName(workspace), id), labels, state, compression, chunkSize)
+}
+
+func splitState(workspace *terraformv1.Workspace, name string, labels map[string]string, state string, compression string, chunkSize int) ([]*corev1.Secret, terraformv1.StateReference, error) {
+	if chunkSize <= 0 {
+		chunkSize = StateChunkSize
+	}
+	ref := terraformv1.StateReference{
+		SecretName:  name,
+		Compression: compression,
+		Digest:      StateDigest(state),
+		Size:        len(state),
+	}
+	data := []byte(state)
+	switch compression {
+	case "":
+	case GzipCompression:
+		buffer := &bytes.Buffer{}
+		writer := gzip.NewWriter(buffer)
+		if _, err := writer.Write(data); err != nil {
+			return nil, ref, fmt.Errorf("failed to compress state: %v", err)
+		}
+		if err := writer.Close(); err != nil {
+			return nil, ref, fmt.Errorf("failed to compress state: %v", err)
+		}
+		data = buffer.Bytes()
+	default:
+		return nil, ref, fmt.Errorf("unknown state compression %q", compression)
+	}
+
+	var secrets []*corev1.Secret
+	for i := 0; i == 0 || len(data) > 0; i++ {
+		end := chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		secrets = append(secrets, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("%s-%d", ref.SecretName, i),
+				Namespace: workspace.Namespace,
+				Labels:    labels,
+			},
+			Type: corev1.SecretTypeOpaque,
+			Data: map[string][]byte{StateSecretKey: data[:end]},
+		})
+		data = data[end:]
+	}
+	ref.Chunks = len(secrets)
+	return secrets, ref, nil
+}
+
+// JoinStateSecrets reassembles the state split across the Secrets of a reference, given in order, and checks it
+// against the digest of the reference
+func JoinStateSecrets(ref terraformv1.StateReference, secrets []*corev1.Secret) (string, error) {
+	if len(secrets) != ref.Chunks {
+		return "", fmt.Errorf("state is split across %d secrets, found %d", ref.Chunks, len(secrets))
+	}
+	var data []byte
+	for _, secret := range secrets {
+		data = append(data, secret.Data[StateSecretKey]...)
+	}
+	switch ref.Compression {
+	case "":
+	case GzipCompression:
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return "", fmt.Errorf("failed to decompress state: %v", err)
+		}
+		if data, err = ioutil.ReadAll(reader); err != nil {
+			return "", fmt.Errorf("failed to decompress state: %v", err)
+		}
+	default:
+		return "", fmt.Errorf("unknown state compression %q", ref.Compression)
+	}
+	state := string(data)
+	if digest := StateDigest(state); digest != ref.Digest {
+		return "", fmt.Errorf("state digest %s does not match %s", digest, ref.Digest)
+	}
+	return state, nil
+}
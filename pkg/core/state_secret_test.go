@@ -0,0 +1,88 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("State Secrets", func() {
+	workspace := &terraformv1.Workspace{ObjectMeta: metav1.ObjectMeta{Name: "vpc", Namespace: "team-a"}}
+	state := fmt.Sprintf(`{"version": 4, "serial": 3, "outputs": {"id": {"value": %q}}}`, strings.Repeat("vpc-0123456789", 100))
+
+	It("round trips state through compressed Secrets", func() {
+		secrets, ref, err := StateSecrets(workspace, state, GzipCompression, StateChunkSize)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(secrets).To(HaveLen(1))
+		Expect(secrets[0].Name).To(Equal("vpc-tfstate-0"))
+		Expect(secrets[0].Namespace).To(Equal("team-a"))
+		Expect(secrets[0].Labels).To(Equal(map[string]string{WorkspaceLabel: "vpc"}))
+		Expect(len(secrets[0].Data[StateSecretKey])).To(BeNumerically("<", len(state)))
+		Expect(ref).To(Equal(terraformv1.StateReference{
+			SecretName:  "vpc-tfstate",
+			Chunks:      1,
+			Compression: GzipCompression,
+			Digest:      StateDigest(state),
+			Size:        len(state),
+		}))
+		Expect(JoinStateSecrets(ref, secrets)).To(Equal(state))
+	})
+
+	It("splits large state across Secrets", func() {
+		secrets, ref, err := StateSecrets(workspace, state, "", 100)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(secrets).To(HaveLen((len(state) + 99) / 100))
+		Expect(ref.Chunks).To(Equal(len(secrets)))
+		Expect(secrets[1].Name).To(Equal("vpc-tfstate-1"))
+		Expect(string(secrets[0].Data[StateSecretKey])).To(Equal(state[:100]))
+		Expect(JoinStateSecrets(ref, secrets)).To(Equal(state))
+	})
+
+	It("keeps empty state in one Secret", func() {
+		secrets, ref, err := StateSecrets(workspace, "", "", 100)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(secrets).To(HaveLen(1))
+		Expect(JoinStateSecrets(ref, secrets)).To(Equal(""))
+	})
+
+	It("detects missing and modified chunks", func() {
+		secrets, ref, err := StateSecrets(workspace, state, "", 100)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = JoinStateSecrets(ref, secrets[1:])
+		Expect(err).To(HaveOccurred())
+
+		secrets[0].Data[StateSecretKey] = []byte(strings.Replace(string(secrets[0].Data[StateSecretKey]), "3", "4", 1))
+		_, err = JoinStateSecrets(ref, secrets)
+		Expect(err).To(MatchError(ContainSubstring("does not match")))
+	})
+
+	It("selects compression with SCIPIAN_STATE_COMPRESSION", func() {
+		defer os.Unsetenv("SCIPIAN_STATE_COMPRESSION")
+		Expect(StateCompression()).To(Equal(GzipCompression))
+		os.Setenv("SCIPIAN_STATE_COMPRESSION", "none")
+		Expect(StateCompression()).To(Equal(""))
+		os.Setenv("SCIPIAN_STATE_COMPRESSION", "zstd")
+		_, err := StateCompression()
+		Expect(err).To(HaveOccurred())
+	})
+})
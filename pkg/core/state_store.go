@@ -0,0 +1,440 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ErrStateNotFound is returned for workspaces, or versions of their state, a store holds no state for
+var ErrStateNotFound = errors.New("state not found")
+
+// StateVersion describes a version of the state of a workspace
+type StateVersion struct {
+	// ID selects the version when getting the state
+	ID string
+	// LastModified is when the version was written, if the store knows
+	LastModified time.Time
+	Size         int64
+	// IsLatest is set on the current state
+	IsLatest bool
+}
+
+// StateStore reads the Terraform state of workspaces from where their backend keeps it. Workspaces are identified by
+// namespace and name.
+type StateStore interface {
+	// Get returns the given version of the state of a workspace, or its current state if version is empty
+	Get(workspace types.NamespacedName, version string) (string, error)
+	// List returns the names of the workspaces of a namespace that have state, in sorted order
+	List(namespace string) ([]string, error)
+	// Versions returns the versions of the state of a workspace, newest first
+	Versions(workspace types.NamespacedName) ([]StateVersion, error)
+}
+
+// StateStoreFor returns the store the state kept in a resolved backend is read from. It returns nil for backends
+// the controller does not read: http backends, and local backends unless SCIPIAN_STATE_DIR is set.
+func StateStoreFor(backend *terraformv1.Backend, c client.Client, accessKey string, secretKey string) (StateStore, error) {
+	switch {
+	case backend.S3 != nil:
+		return &S3StateStore{
+			Bucket:     backend.S3.Bucket,
+			Region:     backend.S3.Region,
+			Endpoint:   backend.S3.Endpoint,
+			AccessKey:  accessKey,
+			SecretKey:  secretKey,
+			RoleARN:    backend.S3.RoleARN,
+			ExternalID: backend.S3.ExternalID,
+		}, nil
+	case backend.Kubernetes != nil:
+		return &SecretStateStore{Client: c, SecretSuffix: backend.Kubernetes.SecretSuffix}, nil
+	case backend.Local != nil:
+		if dir := os.Getenv("SCIPIAN_STATE_DIR"); dir != "" {
+			return &FileStateStore{Dir: dir}, nil
+		}
+		return nil, nil
+	case backend.HTTP != nil:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("no backend to read state from")
+}
+
+// S3StateStore reads state from the S3 bucket of an s3 backend, at <namespace>/<workspace>/terraform.tfstate.
+// Versions are only kept by buckets with versioning enabled. RoleARN is assumed to read the state if set, with the
+// static keys if set, and otherwise with the default credentials of the controller.
+type S3StateStore struct {
+	Bucket     string
+	Region     string
+	Endpoint   string
+	AccessKey  string
+	SecretKey  string
+	RoleARN    string
+	ExternalID string
+}
+
+func (s *S3StateStore) client() (*s3.S3, error) {
+	sess, err := createNewSession(s.Region, s.Endpoint, staticCredentials(s.AccessKey, s.SecretKey), s.RoleARN, s.ExternalID)
+	if err != nil {
+		return nil, err
+	}
+	return s3.New(sess), nil
+}
+
+func (s *S3StateStore) key(workspace types.NamespacedName) string {
+	return fmt.Sprintf("%s/%s/%s", workspace.Namespace, workspace.Name, TFStateFileName)
+}
+
+// Get downloads the state of a workspace
+func (s *S3StateStore) Get(workspace types.NamespacedName, version string) (string, error) {
+	svc, err := s.client()
+	if err != nil {
+		return "", err
+	}
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.key(workspace)),
+	}
+	if version != "" {
+		input.VersionId = aws.String(version)
+	}
+	output, err := svc.GetObject(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case s3.ErrCodeNoSuchKey, "NoSuchVersion", "NotFound":
+				return "", ErrStateNotFound
+			}
+		}
+		return "", fmt.Errorf("failed to download state: %v", err)
+	}
+	defer output.Body.Close()
+	state, err := ioutil.ReadAll(output.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to download state: %v", err)
+	}
+	return string(state), nil
+}
+
+// List lists the workspaces with state under the prefix of a namespace
+func (s *S3StateStore) List(namespace string) ([]string, error) {
+	svc, err := s.client()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	prefix := namespace + "/"
+	if err := svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			parts := strings.Split(strings.TrimPrefix(aws.StringValue(object.Key), prefix), "/")
+			if len(parts) == 2 && parts[1] == TFStateFileName {
+				names = append(names, parts[0])
+			}
+		}
+		return true
+	}); err != nil {
+		return nil, fmt.Errorf("failed to list states: %v", err)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Versions lists the versions of the state object of a workspace. Unversioned buckets list the current state only.
+func (s *S3StateStore) Versions(workspace types.NamespacedName) ([]StateVersion, error) {
+	svc, err := s.client()
+	if err != nil {
+		return nil, err
+	}
+	var versions []StateVersion
+	key := s.key(workspace)
+	if err := svc.ListObjectVersionsPages(&s3.ListObjectVersionsInput{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(key),
+	}, func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+		for _, version := range page.Versions {
+			if aws.StringValue(version.Key) != key {
+				continue
+			}
+			versions = append(versions, StateVersion{
+				ID:           aws.StringValue(version.VersionId),
+				LastModified: aws.TimeValue(version.LastModified),
+				Size:         aws.Int64Value(version.Size),
+				IsLatest:     aws.BoolValue(version.IsLatest),
+			})
+		}
+		return true
+	}); err != nil {
+		return nil, fmt.Errorf("failed to list state versions: %v", err)
+	}
+	sortVersions(versions)
+	return versions, nil
+}
+
+// Labels and data key of the Secrets the Terraform kubernetes backend keeps state in
+const (
+	tfstateKey               = "tfstate"
+	tfstateSecretSuffixLabel = "tfstateSecretSuffix"
+	tfstateWorkspaceLabel    = "tfstateWorkspace"
+)
+
+// SecretStateStore reads state from the gzipped Secrets tfstate-<workspace>-<suffix> of a kubernetes backend. The
+// backend keeps no earlier versions, so the only version is the current one, identified by its resource version.
+type SecretStateStore struct {
+	Client       client.Client
+	SecretSuffix string
+}
+
+func (s *SecretStateStore) secret(workspace types.NamespacedName) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	key := types.NamespacedName{
+		Namespace: workspace.Namespace,
+		Name:      fmt.Sprintf("tfstate-%s-%s", workspace.Name, s.SecretSuffix),
+	}
+	if err := s.Client.Get(context.TODO(), key, secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, ErrStateNotFound
+		}
+		return nil, err
+	}
+	return secret, nil
+}
+
+// Get decompresses the state held by the Secret of a workspace
+func (s *SecretStateStore) Get(workspace types.NamespacedName, version string) (string, error) {
+	secret, err := s.secret(workspace)
+	if err != nil {
+		return "", err
+	}
+	if version != "" && version != secret.ResourceVersion {
+		return "", ErrStateNotFound
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(secret.Data[tfstateKey]))
+	if err != nil {
+		return "", fmt.Errorf("failed to decompress state: %v", err)
+	}
+	defer reader.Close()
+	state, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to decompress state: %v", err)
+	}
+	return string(state), nil
+}
+
+// List lists the workspaces of the state Secrets with the suffix of the store
+func (s *SecretStateStore) List(namespace string) ([]string, error) {
+	secrets := &corev1.SecretList{}
+	if err := s.Client.List(context.TODO(), secrets, client.InNamespace(namespace), client.MatchingLabels{
+		tfstateKey:               "true",
+		tfstateSecretSuffixLabel: s.SecretSuffix,
+	}); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, secret := range secrets.Items {
+		names = append(names, secret.Labels[tfstateWorkspaceLabel])
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Versions returns the current state of a workspace
+func (s *SecretStateStore) Versions(workspace types.NamespacedName) ([]StateVersion, error) {
+	secret, err := s.secret(workspace)
+	if err == ErrStateNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return []StateVersion{{
+		ID:       secret.ResourceVersion,
+		Size:     int64(len(secret.Data[tfstateKey])),
+		IsLatest: true,
+	}}, nil
+}
+
+// FileStateStore reads state from the directories of local backends, with the PersistentVolumeClaim of every
+// namespace available at <dir>/<namespace>. Terraform backs up the state it replaces, so the versions are the current
+// state and its backup.
+type FileStateStore struct {
+	Dir string
+}
+
+// stateBackupFileName is the backup Terraform writes next to the state of a local backend
+const stateBackupFileName = TFStateFileName + ".backup"
+
+func (s *FileStateStore) path(workspace types.NamespacedName, file string) string {
+	return filepath.Join(s.Dir, workspace.Namespace, workspace.Name, file)
+}
+
+// Get reads the state, or with the version terraform.tfstate.backup its backup, of a workspace
+func (s *FileStateStore) Get(workspace types.NamespacedName, version string) (string, error) {
+	file := TFStateFileName
+	if version != "" {
+		if version != TFStateFileName && version != stateBackupFileName {
+			return "", ErrStateNotFound
+		}
+		file = version
+	}
+	state, err := ioutil.ReadFile(s.path(workspace, file))
+	if os.IsNotExist(err) {
+		return "", ErrStateNotFound
+	} else if err != nil {
+		return "", err
+	}
+	return string(state), nil
+}
+
+// List lists the workspace directories of a namespace holding state
+func (s *FileStateStore) List(namespace string) ([]string, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(s.Dir, namespace))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(s.Dir, namespace, entry.Name(), TFStateFileName)); err == nil {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// Versions returns the state of a workspace and its backup, if there is one
+func (s *FileStateStore) Versions(workspace types.NamespacedName) ([]StateVersion, error) {
+	var versions []StateVersion
+	for _, file := range []string{TFStateFileName, stateBackupFileName} {
+		info, err := os.Stat(s.path(workspace, file))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		versions = append(versions, StateVersion{
+			ID:           file,
+			LastModified: info.ModTime(),
+			Size:         info.Size(),
+			IsLatest:     file == TFStateFileName,
+		})
+	}
+	return versions, nil
+}
+
+// MemoryStateStore keeps every version of the states put into it in memory. It stands in for a backend in tests.
+type MemoryStateStore struct {
+	mutex  sync.Mutex
+	states map[types.NamespacedName][]memoryStateVersion
+}
+
+type memoryStateVersion struct {
+	state    string
+	modified time.Time
+}
+
+// Put adds a new version of the state of a workspace
+func (s *MemoryStateStore) Put(workspace types.NamespacedName, state string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.states == nil {
+		s.states = make(map[types.NamespacedName][]memoryStateVersion)
+	}
+	s.states[workspace] = append(s.states[workspace], memoryStateVersion{state: state, modified: time.Now()})
+}
+
+// Get returns the state put with the given version number, counting from 1, or the latest one
+func (s *MemoryStateStore) Get(workspace types.NamespacedName, version string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	versions := s.states[workspace]
+	if len(versions) == 0 {
+		return "", ErrStateNotFound
+	}
+	if version == "" {
+		return versions[len(versions)-1].state, nil
+	}
+	i, err := strconv.Atoi(version)
+	if err != nil || i < 1 || i > len(versions) {
+		return "", ErrStateNotFound
+	}
+	return versions[i-1].state, nil
+}
+
+// List lists the workspaces of a namespace states were put for
+func (s *MemoryStateStore) List(namespace string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var names []string
+	for workspace := range s.states {
+		if workspace.Namespace == namespace {
+			names = append(names, workspace.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Versions lists the states put for a workspace, newest first
+func (s *MemoryStateStore) Versions(workspace types.NamespacedName) ([]StateVersion, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	versions := s.states[workspace]
+	result := make([]StateVersion, 0, len(versions))
+	for i := len(versions) - 1; i >= 0; i-- {
+		result = append(result, StateVersion{
+			ID:           strconv.Itoa(i + 1),
+			LastModified: versions[i].modified,
+			Size:         int64(len(versions[i].state)),
+			IsLatest:     i == len(versions)-1,
+		})
+	}
+	return result, nil
+}
+
+// sortVersions orders versions newest first, keeping the latest version first
+func sortVersions(versions []StateVersion) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].IsLatest != versions[j].IsLatest {
+			return versions[i].IsLatest
+		}
+		return versions[i].LastModified.After(versions[j].LastModified)
+	})
+}
@@ -0,0 +1,267 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// fakeS3 serves the objects of a bucket, each with its versions listed oldest first, and lists them the way S3 does
+func fakeS3(bucket string, objects map[string][]string) *httptest.Server {
+	return httptest.NewServer(fakeS3Handler(bucket, objects))
+}
+
+// fakeS3Handler is the handler of fakeS3
+func fakeS3Handler(bucket string, objects map[string][]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		key := strings.TrimPrefix(r.URL.Path, "/"+bucket)
+		key = strings.TrimPrefix(key, "/")
+		switch {
+		case key == "" && query.Get("list-type") == "2":
+			fmt.Fprint(w, `<ListBucketResult><IsTruncated>false</IsTruncated>`)
+			for name := range objects {
+				if strings.HasPrefix(name, query.Get("prefix")) {
+					fmt.Fprintf(w, `<Contents><Key>%s</Key></Contents>`, name)
+				}
+			}
+			fmt.Fprint(w, `</ListBucketResult>`)
+		case key == "" && r.URL.RawQuery != "" && strings.Contains(r.URL.RawQuery, "versions"):
+			fmt.Fprint(w, `<ListVersionsResult><IsTruncated>false</IsTruncated>`)
+			for name, versions := range objects {
+				if !strings.HasPrefix(name, query.Get("prefix")) {
+					continue
+				}
+				for i := len(versions) - 1; i >= 0; i-- {
+					fmt.Fprintf(w, `<Version><Key>%s</Key><VersionId>v%d</VersionId><IsLatest>%t</IsLatest>`+
+						`<LastModified>2020-01-0%dT00:00:00.000Z</LastModified><Size>%d</Size></Version>`,
+						name, i+1, i == len(versions)-1, i+1, len(versions[i]))
+				}
+			}
+			fmt.Fprint(w, `</ListVersionsResult>`)
+		default:
+			versions := objects[key]
+			version := len(versions)
+			if id := query.Get("versionId"); id != "" {
+				fmt.Sscanf(id, "v%d", &version)
+			}
+			if version < 1 || version > len(versions) {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+				return
+			}
+			fmt.Fprint(w, versions[version-1])
+		}
+	})
+}
+
+var _ = Describe("StateStore", func() {
+	workspace := types.NamespacedName{Namespace: "team-a", Name: "vpc"}
+
+	Context("S3StateStore", func() {
+		var server *httptest.Server
+		var store *S3StateStore
+
+		BeforeEach(func() {
+			server = fakeS3("state", map[string][]string{
+				"team-a/vpc/terraform.tfstate":      {`{"serial": 1}`, `{"serial": 2}`},
+				"team-a/dns/terraform.tfstate":      {`{"serial": 1}`},
+				"team-a/dns/logs/run/run-abcde.log": {"Apply complete!"},
+				"team-b/vpc/terraform.tfstate":      {`{"serial": 1}`},
+			})
+			store = &S3StateStore{Bucket: "state", Region: "us-west-2", Endpoint: server.URL, AccessKey: "a", SecretKey: "b"}
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("gets the current state and earlier versions", func() {
+			Expect(store.Get(workspace, "")).To(Equal(`{"serial": 2}`))
+			Expect(store.Get(workspace, "v1")).To(Equal(`{"serial": 1}`))
+		})
+
+		It("reports missing state", func() {
+			_, err := store.Get(types.NamespacedName{Namespace: "team-a", Name: "missing"}, "")
+			Expect(err).To(Equal(ErrStateNotFound))
+		})
+
+		It("lists the workspaces of a namespace", func() {
+			Expect(store.List("team-a")).To(Equal([]string{"dns", "vpc"}))
+		})
+
+		It("lists versions newest first", func() {
+			versions, err := store.Versions(workspace)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(versions).To(HaveLen(2))
+			Expect(versions[0].ID).To(Equal("v2"))
+			Expect(versions[0].IsLatest).To(BeTrue())
+			Expect(versions[0].Size).To(Equal(int64(len(`{"serial": 2}`))))
+			Expect(versions[1].ID).To(Equal("v1"))
+			Expect(versions[1].LastModified.Before(versions[0].LastModified)).To(BeTrue())
+		})
+	})
+
+	Context("SecretStateStore", func() {
+		gzipped := func(state string) []byte {
+			buffer := &bytes.Buffer{}
+			writer := gzip.NewWriter(buffer)
+			writer.Write([]byte(state))
+			writer.Close()
+			return buffer.Bytes()
+		}
+		stateSecret := func(namespace, name, suffix, state string) *corev1.Secret {
+			return &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      fmt.Sprintf("tfstate-%s-%s", name, suffix),
+					Namespace: namespace,
+					Labels: map[string]string{
+						"tfstate":                      "true",
+						"tfstateWorkspace":             name,
+						"tfstateSecretSuffix":          suffix,
+						"app.kubernetes.io/managed-by": "terraform",
+					},
+				},
+				Data: map[string][]byte{"tfstate": gzipped(state)},
+			}
+		}
+
+		It("reads the Secrets of the kubernetes backend", func() {
+			c := fake.NewFakeClientWithScheme(clientgoscheme.Scheme,
+				stateSecret("team-a", "vpc", "state", `{"serial": 3}`),
+				stateSecret("team-a", "dns", "state", `{"serial": 1}`),
+				stateSecret("team-a", "vpc", "other", `{"serial": 1}`),
+				stateSecret("team-b", "vpc", "state", `{"serial": 1}`),
+			)
+			store := &SecretStateStore{Client: c, SecretSuffix: "state"}
+
+			Expect(store.Get(workspace, "")).To(Equal(`{"serial": 3}`))
+			Expect(store.List("team-a")).To(Equal([]string{"dns", "vpc"}))
+
+			versions, err := store.Versions(workspace)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(versions).To(HaveLen(1))
+			Expect(versions[0].IsLatest).To(BeTrue())
+			Expect(store.Get(workspace, versions[0].ID)).To(Equal(`{"serial": 3}`))
+
+			_, err = store.Get(workspace, "not-a-version")
+			Expect(err).To(Equal(ErrStateNotFound))
+			_, err = store.Get(types.NamespacedName{Namespace: "team-a", Name: "missing"}, "")
+			Expect(err).To(Equal(ErrStateNotFound))
+		})
+	})
+
+	Context("FileStateStore", func() {
+		It("reads the state and backup of local backends", func() {
+			dir, err := ioutil.TempDir("", "state")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(dir)
+			Expect(os.MkdirAll(filepath.Join(dir, "team-a", "vpc"), 0755)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(dir, "team-a", "empty"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "team-a", "vpc", "terraform.tfstate"), []byte(`{"serial": 2}`), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "team-a", "vpc", "terraform.tfstate.backup"), []byte(`{"serial": 1}`), 0644)).To(Succeed())
+			store := &FileStateStore{Dir: dir}
+
+			Expect(store.Get(workspace, "")).To(Equal(`{"serial": 2}`))
+			Expect(store.Get(workspace, "terraform.tfstate.backup")).To(Equal(`{"serial": 1}`))
+			_, err = store.Get(workspace, "../../secret")
+			Expect(err).To(Equal(ErrStateNotFound))
+			Expect(store.List("team-a")).To(Equal([]string{"vpc"}))
+			Expect(store.List("team-b")).To(BeEmpty())
+
+			versions, err := store.Versions(workspace)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(versions).To(HaveLen(2))
+			Expect(versions[0].ID).To(Equal("terraform.tfstate"))
+			Expect(versions[0].IsLatest).To(BeTrue())
+			Expect(versions[1].ID).To(Equal("terraform.tfstate.backup"))
+		})
+	})
+
+	Context("MemoryStateStore", func() {
+		It("keeps every version put into it", func() {
+			store := &MemoryStateStore{}
+			_, err := store.Get(workspace, "")
+			Expect(err).To(Equal(ErrStateNotFound))
+
+			store.Put(workspace, `{"serial": 1}`)
+			store.Put(workspace, `{"serial": 2}`)
+			store.Put(types.NamespacedName{Namespace: "team-b", Name: "dns"}, `{"serial": 1}`)
+
+			Expect(store.Get(workspace, "")).To(Equal(`{"serial": 2}`))
+			Expect(store.Get(workspace, "1")).To(Equal(`{"serial": 1}`))
+			Expect(store.List("team-a")).To(Equal([]string{"vpc"}))
+
+			versions, err := store.Versions(workspace)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(versions).To(HaveLen(2))
+			Expect(versions[0].ID).To(Equal("2"))
+			Expect(versions[0].IsLatest).To(BeTrue())
+			Expect(versions[1].ID).To(Equal("1"))
+		})
+	})
+
+	Context("StateStoreFor", func() {
+		AfterEach(func() {
+			os.Unsetenv("SCIPIAN_STATE_DIR")
+		})
+
+		It("chooses the store of the backend", func() {
+			store, err := StateStoreFor(&terraformv1.Backend{S3: &terraformv1.S3Backend{Bucket: "state", Region: "us-west-2"}}, nil, "a", "b")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(store).To(Equal(&S3StateStore{Bucket: "state", Region: "us-west-2", AccessKey: "a", SecretKey: "b"}))
+
+			store, err = StateStoreFor(&terraformv1.Backend{Kubernetes: &terraformv1.KubernetesBackend{SecretSuffix: "state"}}, nil, "", "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(store).To(Equal(&SecretStateStore{SecretSuffix: "state"}))
+		})
+
+		It("does not read local backends unless their claims are available", func() {
+			backend := &terraformv1.Backend{Local: &terraformv1.LocalBackend{ClaimName: "terraform-state"}}
+			store, err := StateStoreFor(backend, nil, "", "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(store).To(BeNil())
+
+			os.Setenv("SCIPIAN_STATE_DIR", "/var/lib/scipian/state")
+			store, err = StateStoreFor(backend, nil, "", "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(store).To(Equal(&FileStateStore{Dir: "/var/lib/scipian/state"}))
+		})
+
+		It("does not read http backends", func() {
+			store, err := StateStoreFor(&terraformv1.Backend{HTTP: &terraformv1.HTTPBackend{Address: "https://state.example.com"}}, nil, "", "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(store).To(BeNil())
+		})
+	})
+})
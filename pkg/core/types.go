@@ -42,12 +42,46 @@ const (
 	// TFWorkspaceDelete is the Terraform command for deleting an existing Terraform Workspace
 	TFWorkspaceDelete = "cp /opt/meta/* %s && terraform init -force-copy && terraform workspace delete -force %s"
 
-	// TFPlan is the Terraform command for initializing, selecting a workspace, planning, and applying
-	TFPlan = "cp /opt/meta/* %s && terraform init -force-copy && terraform workspace select %s && terraform plan -input=false -out=plan.bin && terraform apply -input=false plan.bin"
+	// TFPlan is the Terraform command for initializing, selecting a workspace, planning, showing the plan as JSON, and
+	// applying
+	TFPlan = "cp /opt/meta/* %s && terraform init -force-copy && terraform workspace select %s && terraform plan -input=false -out=plan.bin && terraform show -json plan.bin && terraform apply -input=false plan.bin"
+
+	// TFPlanOnly is the Terraform command for initializing, selecting a workspace, saving a plan to PlanDir without
+	// applying it, and showing the plan as JSON
+	TFPlanOnly = "cp /opt/meta/* %s && terraform init -force-copy && terraform workspace select %s && terraform plan -input=false -out=/opt/plan/plan.bin && terraform show -json /opt/plan/plan.bin"
+
+	// TFApplyPlan is the Terraform command for initializing, selecting a workspace, and applying a plan saved by TFPlanOnly
+	TFApplyPlan = "cp /opt/meta/* %s && terraform init -force-copy && terraform workspace select %s && terraform apply -input=false /opt/plan/plan.bin"
+
+	// PlanDir is the directory the plan and apply jobs of a Run share the saved plan through
+	PlanDir = "/opt/plan"
 
 	// TFDestroy is the Terraform command for running Terraform destroy on resources
 	TFDestroy = "cp /opt/meta/* %s && terraform init -force-copy && terraform workspace select %s && terraform destroy -auto-approve"
 
+	// TFRefreshOnly is the Terraform command for updating the state to match the real resources without changing them
+	TFRefreshOnly = "cp /opt/meta/* %s && terraform init -force-copy && terraform workspace select %s && terraform apply -refresh-only -input=false -auto-approve"
+
+	// TFImport is the Terraform command for initializing and selecting a workspace, to be followed by a terraform import
+	// for each resource being imported
+	TFImport = "cp /opt/meta/* %s && terraform init -force-copy && terraform workspace select %s"
+
+	// TFStatePush is the Terraform command for initializing, selecting a workspace, and pushing the gzipped state
+	// mounted at RollbackDir over the current state, even though its serial is lower
+	TFStatePush = "cp /opt/meta/* %s && terraform init -force-copy && terraform workspace select %s && gunzip -c /opt/rollback/tfstate.gz | terraform state push -force -"
+
+	// RollbackDir is where the state a rollback Run pushes is mounted
+	RollbackDir = "/opt/rollback"
+
+	// TFForceUnlock is the Terraform command for initializing and selecting a workspace, to be followed by the ID of
+	// the state lock to release
+	TFForceUnlock = "cp /opt/meta/* %s && terraform init -force-copy && terraform workspace select %s && terraform force-unlock -force"
+
+	// TFDriftCheck is the Terraform command for initializing, selecting a workspace, and planning with -detailed-exitcode
+	// to detect drift. The plan exit code is written to the termination message, and the plan is shown as JSON unless
+	// planning failed.
+	TFDriftCheck = "cp /opt/meta/* %s && terraform init -force-copy && terraform workspace select %s && { terraform plan -input=false -detailed-exitcode -out=plan.bin; code=$?; echo $code > /dev/termination-log; [ $code -ne 1 ] && terraform show -json plan.bin; }"
+
 	//TFStateFileName is the name of the terraform state file
 	TFStateFileName = "terraform.tfstate"
 )
@@ -0,0 +1,120 @@
+// Package state parses Terraform state in the version 4 format written by Terraform 0.12 and later
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+)
+
+// SupportedVersion is the version of the state format the parser reads
+const SupportedVersion = 4
+
+// ManagedMode is the mode of resources, as opposed to data sources
+const ManagedMode = "managed"
+
+// State is a Terraform state. Only the fields needed to describe the state are parsed: resource attributes, which
+// can hold secrets, are not.
+type State struct {
+	Version          int               `json:"version"`
+	TerraformVersion string            `json:"terraform_version"`
+	Serial           int64             `json:"serial"`
+	Lineage          string            `json:"lineage"`
+	Outputs          map[string]Output `json:"outputs"`
+	Resources        []Resource        `json:"resources"`
+}
+
+// Output is a root module output recorded in the state
+type Output struct {
+	Sensitive bool            `json:"sensitive,omitempty"`
+	Type      json.RawMessage `json:"type,omitempty"`
+	Value     json.RawMessage `json:"value"`
+}
+
+// Resource is a resource, or data source, with all of its instances
+type Resource struct {
+	// Module is the address of the module of the resource, empty for the root module
+	Module    string     `json:"module,omitempty"`
+	Mode      string     `json:"mode"`
+	Type      string     `json:"type"`
+	Name      string     `json:"name"`
+	Provider  string     `json:"provider"`
+	Instances []Instance `json:"instances"`
+}
+
+// Instance is an instance of a resource, one per count or for_each key
+type Instance struct {
+	// IndexKey is the count index, as a number, or the for_each key, as a string
+	IndexKey json.RawMessage `json:"index_key,omitempty"`
+}
+
+// Parse parses a state, failing on states in other versions of the format
+func Parse(data []byte) (*State, error) {
+	s := &State{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse tfstate: %v", err)
+	}
+	if s.Version != SupportedVersion {
+		return nil, fmt.Errorf("unsupported tfstate version %d", s.Version)
+	}
+	return s, nil
+}
+
+// Summarize parses a state into the summary reported in the Workspace status. Empty state, of a workspace that was
+// never applied, has no summary.
+func Summarize(data []byte) (*terraformv1.StateSummary, error) {
+	if strings.TrimSpace(string(data)) == "" {
+		return nil, nil
+	}
+	s, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	return s.Summary(), nil
+}
+
+// Summary describes the state without any of the values it holds
+func (s *State) Summary() *terraformv1.StateSummary {
+	return &terraformv1.StateSummary{
+		Serial:           s.Serial,
+		Lineage:          s.Lineage,
+		TerraformVersion: s.TerraformVersion,
+		Resources:        s.ResourceCount(),
+		Outputs:          s.OutputNames(),
+	}
+}
+
+// ResourceCount counts the instances of managed resources, leaving out data sources
+func (s *State) ResourceCount() int {
+	count := 0
+	for _, resource := range s.Resources {
+		if resource.Mode == ManagedMode {
+			count += len(resource.Instances)
+		}
+	}
+	return count
+}
+
+// OutputNames returns the names of the outputs in sorted order
+func (s *State) OutputNames() []string {
+	var names []string
+	for name := range s.Outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Serial returns the serial of a state in any version of the format
+func Serial(data []byte) (int64, error) {
+	s := &struct {
+		Serial int64 `json:"serial"`
+	}{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return 0, fmt.Errorf("failed to parse tfstate: %v", err)
+	}
+	return s.Serial, nil
+}
@@ -0,0 +1,86 @@
+package state
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+)
+
+const tfState = `{
+  "version": 4,
+  "terraform_version": "0.12.29",
+  "serial": 7,
+  "lineage": "3f0e1b2c-5d6a-4e7f-8a9b-0c1d2e3f4a5b",
+  "outputs": {
+    "vpc_id": {"value": "vpc-0123", "type": "string"},
+    "db_password": {"value": "hunter2", "type": "string", "sensitive": true}
+  },
+  "resources": [
+    {
+      "mode": "data",
+      "type": "aws_availability_zones",
+      "name": "available",
+      "provider": "provider.aws",
+      "instances": [{"schema_version": 0, "attributes": {"names": ["us-west-2a"]}}]
+    },
+    {
+      "mode": "managed",
+      "type": "aws_vpc",
+      "name": "main",
+      "provider": "provider.aws",
+      "instances": [{"schema_version": 1, "attributes": {"id": "vpc-0123"}}]
+    },
+    {
+      "module": "module.subnets",
+      "mode": "managed",
+      "type": "aws_subnet",
+      "name": "private",
+      "each": "list",
+      "provider": "provider.aws",
+      "instances": [
+        {"index_key": 0, "schema_version": 1, "attributes": {"id": "subnet-0"}},
+        {"index_key": 1, "schema_version": 1, "attributes": {"id": "subnet-1"}}
+      ]
+    }
+  ]
+}`
+
+var _ = Describe("State", func() {
+	Context("Summarize", func() {
+		It("Should describe a version 4 state", func() {
+			Expect(Summarize([]byte(tfState))).Should(Equal(&terraformv1.StateSummary{
+				Serial:           7,
+				Lineage:          "3f0e1b2c-5d6a-4e7f-8a9b-0c1d2e3f4a5b",
+				TerraformVersion: "0.12.29",
+				Resources:        3,
+				Outputs:          []string{"db_password", "vpc_id"},
+			}))
+		})
+
+		It("Should describe the state of a workspace without resources", func() {
+			Expect(Summarize([]byte(`{"version": 4, "terraform_version": "0.13.5", "serial": 1, "lineage": "a", "outputs": {}, "resources": []}`))).
+				Should(Equal(&terraformv1.StateSummary{Serial: 1, Lineage: "a", TerraformVersion: "0.13.5"}))
+		})
+
+		It("Should not summarize empty state", func() {
+			Expect(Summarize([]byte("  \n"))).Should(BeNil())
+		})
+
+		It("Should fail on other versions of the format", func() {
+			_, err := Summarize([]byte(`{"version": 3, "terraform_version": "0.11.14", "serial": 2, "modules": []}`))
+			Expect(err).Should(MatchError("unsupported tfstate version 3"))
+		})
+
+		It("Should fail on invalid JSON", func() {
+			_, err := Parse([]byte(`{"version": 4`))
+			Expect(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("Serial", func() {
+		It("Should read the serial of any version of the format", func() {
+			Expect(Serial([]byte(tfState))).Should(Equal(int64(7)))
+			Expect(Serial([]byte(`{"version": 3, "serial": 2, "modules": []}`))).Should(Equal(int64(2)))
+		})
+	})
+})
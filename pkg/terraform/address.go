@@ -0,0 +1,38 @@
+package terraform
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	addressName  = `[A-Za-z_][A-Za-z0-9_-]*`
+	addressIndex = `(\[([0-9]+|"([^"\\]|\\.)*")\])?`
+	moduleStep   = `module\.` + addressName + addressIndex
+)
+
+var (
+	// resourceAddress matches the address of a resource or resource instance, e.g. module.vpc.aws_subnet.private[0]
+	resourceAddress = regexp.MustCompile(`^(` + moduleStep + `\.)*(data\.)?` + addressName + `\.` + addressName + addressIndex + `$`)
+	// moduleAddress matches the address of a module or module instance, e.g. module.vpc
+	moduleAddress = regexp.MustCompile(`^` + moduleStep + `(\.` + moduleStep + `)*$`)
+)
+
+// ValidateTarget checks that a -target address is a well-formed resource or module address
+func ValidateTarget(address string) error {
+	if !resourceAddress.MatchString(address) && !moduleAddress.MatchString(address) {
+		return fmt.Errorf("invalid target address %q", address)
+	}
+	return nil
+}
+
+// ValidateReplace checks that a -replace address is a well-formed resource address
+func ValidateReplace(address string) error {
+	// Module addresses look like resource addresses of type "module", which Terraform reserves
+	if !resourceAddress.MatchString(address) || moduleAddress.MatchString(address) ||
+		strings.HasPrefix(address, "data.") || strings.Contains(address, ".data.") {
+		return fmt.Errorf("invalid replace address %q", address)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package terraform
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Address", func() {
+
+	Context("Validate target", func() {
+		It("Should accept resource and module addresses", func() {
+			for _, address := range []string{
+				"aws_instance.web",
+				"aws_instance.web[0]",
+				`aws_instance.web["blue"]`,
+				"data.aws_ami.ubuntu",
+				"module.vpc",
+				`module.vpc["east"].module.subnets[1]`,
+				"module.vpc.aws_subnet.private[2]",
+			} {
+				Expect(ValidateTarget(address)).To(Succeed(), address)
+			}
+		})
+		It("Should reject malformed addresses", func() {
+			for _, address := range []string{
+				"",
+				"aws_instance",
+				"aws_instance.web[",
+				"aws_instance.web; rm -rf /",
+				"module.",
+				"-target=aws_instance.web",
+			} {
+				Expect(ValidateTarget(address)).NotTo(Succeed(), address)
+			}
+		})
+	})
+
+	Context("Validate replace", func() {
+		It("Should accept resource addresses", func() {
+			Expect(ValidateReplace("module.vpc.aws_subnet.private[2]")).To(Succeed())
+		})
+		It("Should reject module and data source addresses", func() {
+			Expect(ValidateReplace("module.vpc")).NotTo(Succeed())
+			Expect(ValidateReplace("data.aws_ami.ubuntu")).NotTo(Succeed())
+			Expect(ValidateReplace("module.vpc.data.aws_ami.ubuntu")).NotTo(Succeed())
+		})
+	})
+})
@@ -0,0 +1,103 @@
+package terraform
+
+import (
+	"fmt"
+	"strings"
+
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// WebIdentityRoleAnnotation is the annotation of a ServiceAccount holding its IAM role for service accounts (IRSA)
+	WebIdentityRoleAnnotation = "eks.amazonaws.com/role-arn"
+
+	// AWSConfigKey is the ConfigMap key holding the shared AWS config of a job
+	AWSConfigKey = "aws-config"
+
+	// AWSProfile is the profile of the shared AWS config the Terraform providers use
+	AWSProfile = "scipian"
+
+	awsConfigDir          = "/opt/aws"
+	awsTokenDir           = "/var/run/secrets/scipian/serviceaccount"
+	awsWebIdentityProfile = "scipian-web-identity"
+	awsTokenAudience      = "sts.amazonaws.com"
+	awsTokenExpiration    = int64(86400)
+)
+
+// AddAssumeRole makes the Terraform providers of a job assume the AssumeRoleARN of its workspace, through a profile of
+// a shared AWS config kept in the ConfigMap of the job. The role is assumed with the credentials of the Secret of the
+// workspace if it has one, otherwise with the web identity of its ServiceAccount if the ServiceAccount has an IAM
+// role, and otherwise with the credentials of the instance the job runs on.
+func AddAssumeRole(job *batchv1.Job, configMap *corev1.ConfigMap, ws *terraformv1.Workspace, webIdentityRoleARN string) {
+	if ws.Spec.AssumeRoleARN == "" {
+		return
+	}
+	podSpec := &job.Spec.Template.Spec
+	webIdentity := ws.Spec.Secret == "" && webIdentityRoleARN != ""
+
+	configMap.Data[AWSConfigKey] = formatAWSConfig(ws, webIdentityRoleARN)
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: "aws-config",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: configMap.Name},
+				Items:                []corev1.KeyToPath{{Key: AWSConfigKey, Path: "config"}},
+			},
+		},
+	})
+	mounts := []corev1.VolumeMount{{Name: "aws-config", MountPath: awsConfigDir, ReadOnly: true}}
+	env := []corev1.EnvVar{
+		{Name: "AWS_CONFIG_FILE", Value: awsConfigDir + "/config"},
+		{Name: "AWS_PROFILE", Value: AWSProfile},
+		{Name: "AWS_SDK_LOAD_CONFIG", Value: "1"},
+	}
+	if webIdentity {
+		// The token is projected by the job itself, and the variables the EKS webhook would otherwise inject are
+		// left empty, as the AWS SDKs prefer them to the profile and would not assume the role
+		expiration := awsTokenExpiration
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: "aws-token",
+			VolumeSource: corev1.VolumeSource{
+				Projected: &corev1.ProjectedVolumeSource{
+					Sources: []corev1.VolumeProjection{{
+						ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+							Audience:          awsTokenAudience,
+							ExpirationSeconds: &expiration,
+							Path:              "token",
+						},
+					}},
+				},
+			},
+		})
+		mounts = append(mounts, corev1.VolumeMount{Name: "aws-token", MountPath: awsTokenDir, ReadOnly: true})
+		env = append(env, corev1.EnvVar{Name: "AWS_ROLE_ARN"}, corev1.EnvVar{Name: "AWS_WEB_IDENTITY_TOKEN_FILE"})
+	}
+	for i := range podSpec.Containers {
+		podSpec.Containers[i].VolumeMounts = append(podSpec.Containers[i].VolumeMounts, mounts...)
+		podSpec.Containers[i].Env = append(podSpec.Containers[i].Env, env...)
+	}
+}
+
+// formatAWSConfig renders the shared AWS config whose profile assumes the AssumeRoleARN of a workspace
+func formatAWSConfig(ws *terraformv1.Workspace, webIdentityRoleARN string) string {
+	var config strings.Builder
+	fmt.Fprintf(&config, "[profile %s]\n", AWSProfile)
+	fmt.Fprintf(&config, "role_arn = %s\n", ws.Spec.AssumeRoleARN)
+	if ws.Spec.ExternalID != "" {
+		fmt.Fprintf(&config, "external_id = %s\n", ws.Spec.ExternalID)
+	}
+	switch {
+	case ws.Spec.Secret != "":
+		config.WriteString("credential_source = Environment\n")
+	case webIdentityRoleARN != "":
+		fmt.Fprintf(&config, "source_profile = %s\n", awsWebIdentityProfile)
+		fmt.Fprintf(&config, "\n[profile %s]\n", awsWebIdentityProfile)
+		fmt.Fprintf(&config, "role_arn = %s\n", webIdentityRoleARN)
+		fmt.Fprintf(&config, "web_identity_token_file = %s/token\n", awsTokenDir)
+	default:
+		config.WriteString("credential_source = Ec2InstanceMetadata\n")
+	}
+	return config.String()
+}
@@ -0,0 +1,90 @@
+package terraform
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	"github.com/scipian/terraform-controller/pkg/core"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("AssumeRole", func() {
+	key := types.NamespacedName{Namespace: "team-a", Name: "vpc-run"}
+	webIdentityRoleARN := "arn:aws:iam::123456789012:role/terraform"
+	newWorkspace := func(secret string) *terraformv1.Workspace {
+		return &terraformv1.Workspace{
+			ObjectMeta: metav1.ObjectMeta{Name: "vpc", Namespace: "team-a"},
+			Spec: terraformv1.WorkspaceSpec{
+				Image:              "test-image",
+				WorkingDir:         "/test",
+				Secret:             secret,
+				ServiceAccountName: "terraform",
+				AssumeRoleARN:      "arn:aws:iam::210987654321:role/vpc",
+				ExternalID:         "team-a",
+			},
+		}
+	}
+	newConfigMap := func() *corev1.ConfigMap {
+		return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: key.Name}, Data: map[string]string{}}
+	}
+
+	It("Should assume the role with the web identity of the service account", func() {
+		ws := newWorkspace("")
+		job := CreateJob(key, core.TFPlan, ws, false)
+		configMap := newConfigMap()
+		AddAssumeRole(job, configMap, ws, webIdentityRoleARN)
+
+		Expect(configMap.Data[AWSConfigKey]).Should(Equal(`[profile scipian]
+role_arn = arn:aws:iam::210987654321:role/vpc
+external_id = team-a
+source_profile = scipian-web-identity
+
+[profile scipian-web-identity]
+role_arn = arn:aws:iam::123456789012:role/terraform
+web_identity_token_file = /var/run/secrets/scipian/serviceaccount/token
+`))
+		podSpec := job.Spec.Template.Spec
+		Expect(podSpec.Volumes).Should(HaveLen(3))
+		Expect(podSpec.Volumes[1].ConfigMap.Name).Should(Equal("vpc-run"))
+		Expect(podSpec.Volumes[2].Projected.Sources[0].ServiceAccountToken.Audience).Should(Equal("sts.amazonaws.com"))
+		container := podSpec.Containers[0]
+		Expect(container.VolumeMounts).Should(ContainElement(corev1.VolumeMount{Name: "aws-config", MountPath: "/opt/aws", ReadOnly: true}))
+		Expect(container.VolumeMounts).Should(ContainElement(corev1.VolumeMount{
+			Name: "aws-token", MountPath: "/var/run/secrets/scipian/serviceaccount", ReadOnly: true,
+		}))
+		Expect(container.Env).Should(ContainElement(corev1.EnvVar{Name: "AWS_CONFIG_FILE", Value: "/opt/aws/config"}))
+		Expect(container.Env).Should(ContainElement(corev1.EnvVar{Name: "AWS_PROFILE", Value: "scipian"}))
+		Expect(container.Env).Should(ContainElement(corev1.EnvVar{Name: "AWS_WEB_IDENTITY_TOKEN_FILE"}))
+	})
+
+	It("Should assume the role with the credentials of the Secret", func() {
+		ws := newWorkspace("vpc-creds")
+		job := CreateJob(key, core.TFPlan, ws, false)
+		configMap := newConfigMap()
+		AddAssumeRole(job, configMap, ws, webIdentityRoleARN)
+
+		Expect(configMap.Data[AWSConfigKey]).Should(HaveSuffix("credential_source = Environment\n"))
+		Expect(job.Spec.Template.Spec.Volumes).Should(HaveLen(2))
+		Expect(job.Spec.Template.Spec.Containers[0].Env).ShouldNot(ContainElement(corev1.EnvVar{Name: "AWS_WEB_IDENTITY_TOKEN_FILE"}))
+	})
+
+	It("Should fall back to the credentials of the instance", func() {
+		ws := newWorkspace("")
+		job := CreateJob(key, core.TFPlan, ws, false)
+		configMap := newConfigMap()
+		AddAssumeRole(job, configMap, ws, "")
+		Expect(configMap.Data[AWSConfigKey]).Should(HaveSuffix("credential_source = Ec2InstanceMetadata\n"))
+	})
+
+	It("Should leave jobs of workspaces without a role alone", func() {
+		ws := newWorkspace("")
+		ws.Spec.AssumeRoleARN = ""
+		job := CreateJob(key, core.TFPlan, ws, false)
+		configMap := newConfigMap()
+		AddAssumeRole(job, configMap, ws, webIdentityRoleARN)
+		Expect(configMap.Data).Should(BeEmpty())
+		Expect(job.Spec.Template.Spec.Volumes).Should(HaveLen(1))
+	})
+})
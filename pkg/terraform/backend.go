@@ -0,0 +1,138 @@
+package terraform
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	"github.com/scipian/terraform-controller/pkg/core"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// StateDir is where the PersistentVolumeClaim of a local backend is mounted
+	StateDir = "/opt/state"
+
+	stateVolumeName = "state"
+)
+
+// workspaceCommand matches the Terraform workspace commands, which fail on backends with only the default workspace
+var workspaceCommand = regexp.MustCompile(`terraform workspace (new|select|delete -force) [^ ;&|]+`)
+
+// hclEscaper escapes a string for a quoted HCL string, including the template sequences HCL would interpolate
+var hclEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`, "${", "$${", "%{", "%%{")
+
+// backendAttribute is an attribute of a backend block, with its value already in HCL
+type backendAttribute struct {
+	name  string
+	value string
+}
+
+func hclString(s string) string {
+	return fmt.Sprintf(`"%s"`, hclEscaper.Replace(s))
+}
+
+// formatBackendTerraform renders the backend.tf keeping the state of a workspace in a resolved backend. The
+// credentials of s3 backends are given to terraform init by AddCredentials.
+func formatBackendTerraform(backend *terraformv1.Backend, ws *terraformv1.Workspace) (string, error) {
+	var attributes []backendAttribute
+	switch {
+	case backend.S3 != nil:
+		s3 := backend.S3
+		attributes = []backendAttribute{
+			{"bucket", hclString(s3.Bucket)},
+			{"key", hclString("terraform.tfstate")},
+			{"region", hclString(s3.Region)},
+		}
+		if s3.DynamoDBTable != "" {
+			attributes = append(attributes, backendAttribute{"dynamodb_table", hclString(s3.DynamoDBTable)})
+		}
+		attributes = append(attributes, backendAttribute{"workspace_key_prefix", hclString(ws.Namespace)})
+		if s3.RoleARN != "" {
+			attributes = append(attributes, backendAttribute{"role_arn", hclString(s3.RoleARN)})
+			if s3.ExternalID != "" {
+				attributes = append(attributes, backendAttribute{"external_id", hclString(s3.ExternalID)})
+			}
+		}
+		if s3.Endpoint != "" {
+			attributes = append(attributes,
+				backendAttribute{"endpoint", hclString(s3.Endpoint)},
+				backendAttribute{"force_path_style", "true"},
+				backendAttribute{"skip_credentials_validation", "true"},
+				backendAttribute{"skip_metadata_api_check", "true"},
+				backendAttribute{"skip_region_validation", "true"},
+			)
+		}
+	case backend.Kubernetes != nil:
+		attributes = []backendAttribute{
+			{"secret_suffix", hclString(backend.Kubernetes.SecretSuffix)},
+			{"namespace", hclString(ws.Namespace)},
+			{"in_cluster_config", "true"},
+		}
+	case backend.HTTP != nil:
+		// Every workspace gets its own addresses, as the backend only has the default Terraform workspace
+		http := backend.HTTP
+		attributes = []backendAttribute{{"address", hclString(workspaceAddress(http.Address, ws))}}
+		if http.LockAddress != "" {
+			attributes = append(attributes,
+				backendAttribute{"lock_address", hclString(workspaceAddress(http.LockAddress, ws))},
+				backendAttribute{"unlock_address", hclString(workspaceAddress(http.UnlockAddress, ws))},
+			)
+		}
+	case backend.Local != nil:
+		attributes = []backendAttribute{
+			{"path", hclString(path.Join(StateDir, "terraform.tfstate"))},
+			{"workspace_dir", hclString(StateDir)},
+		}
+	default:
+		return "", fmt.Errorf("workspace/%s has no backend", ws.Name)
+	}
+
+	width := 0
+	for _, attribute := range attributes {
+		if len(attribute.name) > width {
+			width = len(attribute.name)
+		}
+	}
+	body := ""
+	for _, attribute := range attributes {
+		body += fmt.Sprintf("\t\t%-*s = %s\n", width, attribute.name, attribute.value)
+	}
+	return fmt.Sprintf(BackendTemplate, core.BackendType(backend), body), nil
+}
+
+// workspaceAddress appends the namespace and name of a workspace to an address of the http backend
+func workspaceAddress(address string, ws *terraformv1.Workspace) string {
+	return fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(address, "/"), ws.Namespace, ws.Name)
+}
+
+// AddBackend adds what a resolved backend needs to a job: local backends mount their PersistentVolumeClaim, and
+// http backends, which only have the default Terraform workspace, skip the Terraform workspace commands
+func AddBackend(job *batchv1.Job, backend *terraformv1.Backend) {
+	podSpec := &job.Spec.Template.Spec
+	switch {
+	case backend.Local != nil:
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: stateVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: backend.Local.ClaimName},
+			},
+		})
+		for i := range podSpec.Containers {
+			podSpec.Containers[i].VolumeMounts = append(podSpec.Containers[i].VolumeMounts, corev1.VolumeMount{
+				Name:      stateVolumeName,
+				MountPath: StateDir,
+			})
+		}
+	case backend.HTTP != nil:
+		for i := range podSpec.Containers {
+			args := podSpec.Containers[i].Args
+			for j := range args {
+				args[j] = workspaceCommand.ReplaceAllString(args[j], "true")
+			}
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package terraform
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	"github.com/scipian/terraform-controller/pkg/core"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("Backend", func() {
+	key := types.NamespacedName{Namespace: "team-a", Name: "vpc-run"}
+	ws := &terraformv1.Workspace{
+		ObjectMeta: metav1.ObjectMeta{Name: "vpc", Namespace: "team-a"},
+		Spec:       terraformv1.WorkspaceSpec{Image: "test-image", WorkingDir: "/test"},
+	}
+
+	Context("Format Terraform Backend", func() {
+		It("Should address S3-compatible stores by path", func() {
+			backend := &terraformv1.Backend{S3: &terraformv1.S3Backend{
+				Bucket:   "state",
+				Region:   "us-east-1",
+				Endpoint: "http://minio.minio:9000",
+			}}
+			Expect(formatBackendTerraform(backend, ws)).Should(Equal(`
+terraform {
+	backend "s3" {
+		bucket                      = "state"
+		key                         = "terraform.tfstate"
+		region                      = "us-east-1"
+		workspace_key_prefix        = "team-a"
+		endpoint                    = "http://minio.minio:9000"
+		force_path_style            = true
+		skip_credentials_validation = true
+		skip_metadata_api_check     = true
+		skip_region_validation      = true
+	}
+}
+	`))
+		})
+
+		It("Should assume the role of S3 backends", func() {
+			backend := &terraformv1.Backend{S3: &terraformv1.S3Backend{
+				Bucket:     "state",
+				Region:     "us-east-1",
+				RoleARN:    "arn:aws:iam::123456789012:role/state",
+				ExternalID: "scipian",
+			}}
+			Expect(formatBackendTerraform(backend, ws)).Should(Equal(`
+terraform {
+	backend "s3" {
+		bucket               = "state"
+		key                  = "terraform.tfstate"
+		region               = "us-east-1"
+		workspace_key_prefix = "team-a"
+		role_arn             = "arn:aws:iam::123456789012:role/state"
+		external_id          = "scipian"
+	}
+}
+	`))
+		})
+
+		It("Should keep state in a Secret of the workspace namespace", func() {
+			backend := &terraformv1.Backend{Kubernetes: &terraformv1.KubernetesBackend{SecretSuffix: "state"}}
+			Expect(formatBackendTerraform(backend, ws)).Should(Equal(`
+terraform {
+	backend "kubernetes" {
+		secret_suffix     = "state"
+		namespace         = "team-a"
+		in_cluster_config = true
+	}
+}
+	`))
+		})
+
+		It("Should give every workspace its own http addresses", func() {
+			backend := &terraformv1.Backend{HTTP: &terraformv1.HTTPBackend{
+				Address:       "https://state.example.com/state/",
+				LockAddress:   "https://state.example.com/lock",
+				UnlockAddress: "https://state.example.com/unlock",
+			}}
+			Expect(formatBackendTerraform(backend, ws)).Should(Equal(`
+terraform {
+	backend "http" {
+		address        = "https://state.example.com/state/team-a/vpc"
+		lock_address   = "https://state.example.com/lock/team-a/vpc"
+		unlock_address = "https://state.example.com/unlock/team-a/vpc"
+	}
+}
+	`))
+
+			backend.HTTP.LockAddress = ""
+			Expect(formatBackendTerraform(backend, ws)).ShouldNot(ContainSubstring("lock_address"))
+		})
+
+		It("Should keep state on the mounted claim", func() {
+			backend := &terraformv1.Backend{Local: &terraformv1.LocalBackend{ClaimName: "terraform-state"}}
+			Expect(formatBackendTerraform(backend, ws)).Should(Equal(`
+terraform {
+	backend "local" {
+		path          = "/opt/state/terraform.tfstate"
+		workspace_dir = "/opt/state"
+	}
+}
+	`))
+		})
+
+		It("Should escape values", func() {
+			backend := &terraformv1.Backend{S3: &terraformv1.S3Backend{Bucket: "state", Region: "us-west-2"}}
+			backend.S3.Bucket = "a\"b\\c\n${d}"
+			config, err := formatBackendTerraform(backend, ws)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config).Should(ContainSubstring(`bucket               = "a\"b\\c\n$${d}"`))
+		})
+
+		It("Should fail without a backend", func() {
+			_, err := formatBackendTerraform(&terraformv1.Backend{}, ws)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Add backend", func() {
+		It("Should mount the claim of a local backend", func() {
+			job := CreateJob(key, core.TFPlan, ws, false)
+			AddBackend(job, &terraformv1.Backend{Local: &terraformv1.LocalBackend{ClaimName: "terraform-state"}})
+
+			Expect(job.Spec.Template.Spec.Volumes).Should(ContainElement(corev1.Volume{
+				Name: "state",
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "terraform-state"},
+				},
+			}))
+			Expect(job.Spec.Template.Spec.Containers[0].VolumeMounts).Should(ContainElement(corev1.VolumeMount{
+				Name:      "state",
+				MountPath: StateDir,
+			}))
+		})
+
+		It("Should skip the workspace commands of an http backend", func() {
+			backend := &terraformv1.Backend{HTTP: &terraformv1.HTTPBackend{Address: "https://state.example.com"}}
+			for _, tfCmd := range []string{core.TFWorkspaceNew, core.TFPlan, core.TFWorkspaceDelete, core.TFDriftCheck} {
+				job := CreateJob(key, tfCmd, ws, false)
+				AddBackend(job, backend)
+				Expect(job.Spec.Template.Spec.Containers[0].Args[1]).ShouldNot(ContainSubstring("terraform workspace"))
+			}
+
+			job := CreateJob(key, core.TFPlan, ws, false)
+			AddBackend(job, backend)
+			Expect(job.Spec.Template.Spec.Containers[0].Args[1]).Should(HavePrefix(
+				"cp /opt/meta/* /test && terraform init -force-copy && true && terraform plan"))
+		})
+
+		It("Should leave other jobs alone", func() {
+			job := CreateJob(key, core.TFPlan, ws, false)
+			expected := job.DeepCopy()
+			AddBackend(job, &terraformv1.Backend{Kubernetes: &terraformv1.KubernetesBackend{SecretSuffix: "state"}})
+			AddBackend(job, &terraformv1.Backend{S3: &terraformv1.S3Backend{Bucket: "state"}})
+			Expect(job).Should(Equal(expected))
+		})
+	})
+})
@@ -0,0 +1,50 @@
+package terraform
+
+import (
+	"strings"
+
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	"github.com/scipian/terraform-controller/pkg/core"
+)
+
+// ResourceFlags adds a -target flag for each of targets and a -replace flag for each of replace to the plan
+// commands of a Terraform command template. Destroy and refresh-only commands only take the -target flags.
+func ResourceFlags(tfCmd string, targets []string, replace []string) string {
+	var targetFlags, replaceFlags string
+	for _, address := range targets {
+		targetFlags = targetFlags + " " + shellQuote("-target="+address)
+	}
+	for _, address := range replace {
+		replaceFlags = replaceFlags + " " + shellQuote("-replace="+address)
+	}
+	// The template is formatted again by CreateJob
+	targetFlags = strings.Replace(targetFlags, "%", "%%", -1)
+	replaceFlags = strings.Replace(replaceFlags, "%", "%%", -1)
+
+	tfCmd = strings.Replace(tfCmd, "terraform plan", "terraform plan"+targetFlags+replaceFlags, -1)
+	tfCmd = strings.Replace(tfCmd, "terraform destroy", "terraform destroy"+targetFlags, -1)
+	tfCmd = strings.Replace(tfCmd, "terraform apply -refresh-only", "terraform apply -refresh-only"+targetFlags, -1)
+	return tfCmd
+}
+
+// ImportCommand returns a Terraform command template that imports each of the given resources
+func ImportCommand(imports []terraformv1.ImportResource) string {
+	tfCmd := core.TFImport
+	for _, resource := range imports {
+		importCmd := " && terraform import -input=false " + shellQuote(resource.Address) + " " + shellQuote(resource.ID)
+		// The template is formatted again by CreateJob
+		tfCmd = tfCmd + strings.Replace(importCmd, "%", "%%", -1)
+	}
+	return tfCmd
+}
+
+// ForceUnlockCommand returns a Terraform command template that releases the state lock with the given ID
+func ForceUnlockCommand(lockID string) string {
+	// The template is formatted again by CreateJob
+	return core.TFForceUnlock + " " + strings.Replace(shellQuote(lockID), "%", "%%", -1)
+}
+
+// shellQuote quotes s as a single word for the ash shell running Terraform
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
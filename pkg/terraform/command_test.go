@@ -0,0 +1,58 @@
+package terraform
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	"github.com/scipian/terraform-controller/pkg/core"
+)
+
+var _ = Describe("Command", func() {
+
+	Context("Resource flags", func() {
+		It("Should add targets and replacements to the plan command", func() {
+			tfCmd := ResourceFlags(core.TFPlan, []string{`aws_instance.web["blue"]`}, []string{"aws_instance.db"})
+			Expect(tfCmd).Should(ContainSubstring(`terraform plan '-target=aws_instance.web["blue"]' '-replace=aws_instance.db' -input=false`))
+			Expect(fmt.Sprintf(tfCmd, "dir", "name")).ShouldNot(ContainSubstring("%!"))
+		})
+		It("Should only add targets to the destroy command", func() {
+			tfCmd := ResourceFlags(core.TFDestroy, []string{"module.vpc"}, []string{"aws_instance.db"})
+			Expect(tfCmd).Should(ContainSubstring("terraform destroy '-target=module.vpc' -auto-approve"))
+			Expect(tfCmd).ShouldNot(ContainSubstring("-replace"))
+		})
+		It("Should escape quotes and format verbs", func() {
+			tfCmd := ResourceFlags(core.TFPlan, []string{`aws_instance.web["it's 100%"]`}, nil)
+			Expect(fmt.Sprintf(tfCmd, "dir", "name")).Should(ContainSubstring(`'-target=aws_instance.web["it'\''s 100%"]'`))
+		})
+		It("Should only add targets to the refresh-only command", func() {
+			tfCmd := ResourceFlags(core.TFRefreshOnly, []string{"aws_instance.web"}, []string{"aws_instance.db"})
+			Expect(tfCmd).Should(ContainSubstring("terraform apply -refresh-only '-target=aws_instance.web' -input=false"))
+			Expect(tfCmd).ShouldNot(ContainSubstring("-replace"))
+		})
+		It("Should leave the command unchanged without addresses", func() {
+			Expect(ResourceFlags(core.TFPlan, nil, nil)).Should(Equal(core.TFPlan))
+		})
+	})
+
+	Context("Import command", func() {
+		It("Should import each resource", func() {
+			tfCmd := ImportCommand([]terraformv1.ImportResource{
+				{Address: "aws_s3_bucket.logs", ID: "my-logs"},
+				{Address: `aws_iam_role.app["web"]`, ID: "app-100%"},
+			})
+			Expect(fmt.Sprintf(tfCmd, "dir", "name")).Should(Equal("cp /opt/meta/* dir && terraform init -force-copy && terraform workspace select name" +
+				" && terraform import -input=false 'aws_s3_bucket.logs' 'my-logs'" +
+				` && terraform import -input=false 'aws_iam_role.app["web"]' 'app-100%'`))
+		})
+	})
+
+	Context("Force unlock command", func() {
+		It("Should release the given lock", func() {
+			tfCmd := ForceUnlockCommand("5f3c2b9e-0c1d-4f7a-9a3e-2d1b4c5e6f70")
+			Expect(fmt.Sprintf(tfCmd, "dir", "name")).Should(Equal("cp /opt/meta/* dir && terraform init -force-copy && terraform workspace select name" +
+				" && terraform force-unlock -force '5f3c2b9e-0c1d-4f7a-9a3e-2d1b4c5e6f70'"))
+		})
+	})
+})
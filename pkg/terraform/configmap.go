@@ -1,6 +1,7 @@
 package terraform
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 
@@ -10,27 +11,42 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 )
 
+const (
+	// TfVarsKey is the ConfigMap key holding the terraform.tfvars.json document of a job
+	TfVarsKey = "terraform-tfvars-json"
+
+	// NamespaceVariable is the backend variable holding the namespace of the Workspace
+	NamespaceVariable = "network_workspace_namespace"
+
+	// StateBucketVariable is the backend variable holding the state bucket of the controller
+	StateBucketVariable = "state_bucket_name"
+)
+
 // +kubebuilder:rbac:groups=core,resources=configmaps;secrets;pods;pods/volumes,verbs=get;list;watch;create;update;patch;delete
 
-// CreateConfigMap creates a Kubernetes Configmap with variables that the Terraform Job will reference
-func CreateConfigMap(key types.NamespacedName, accessKey string, secretKey string, ws *terraformv1.Workspace) *corev1.ConfigMap {
+// CreateConfigMap creates a Kubernetes Configmap with variables that the Terraform Job will reference, and the
+// configuration of the backend resolved for the workspace. It holds no credentials, which AddCredentials hands to the
+// job instead.
+func CreateConfigMap(key types.NamespacedName, backend *terraformv1.Backend, ws *terraformv1.Workspace) (*corev1.ConfigMap, error) {
 	scipianBucket := os.Getenv("SCIPIAN_STATE_BUCKET")
-	scipianStateLocking := os.Getenv("SCIPIAN_STATE_LOCKING")
-	//TODO(NL): Add error handling here if ENV's are empty
 
 	backendVariableMap := map[string]string{
-		"network_workspace_namespace": ws.Namespace,
-		"state_bucket_name":           scipianBucket,
-		"access_key":                  accessKey,
-		"secret_key":                  secretKey,
+		NamespaceVariable:   ws.Namespace,
+		StateBucketVariable: scipianBucket,
 	}
 
-	backendTF := formatBackendTerraform(scipianBucket, scipianStateLocking, accessKey, secretKey, ws)
-	tfVars := formatTerraformVars(backendVariableMap, ws)
+	backendTF, err := formatBackendTerraform(backend, ws)
+	if err != nil {
+		return nil, err
+	}
+	tfVars, err := formatTerraformVars(backendVariableMap, ws)
+	if err != nil {
+		return nil, err
+	}
 
 	configMapData := make(map[string]string)
 	configMapData["backend-tf"] = backendTF
-	configMapData["terraform-tfvars"] = tfVars
+	configMapData[TfVarsKey] = tfVars
 
 	return &corev1.ConfigMap{
 		TypeMeta: metav1.TypeMeta{
@@ -43,38 +59,29 @@ func CreateConfigMap(key types.NamespacedName, accessKey string, secretKey strin
 			Labels:    make(map[string]string),
 		},
 		Data: configMapData,
-	}
+	}, nil
 }
 
-func formatBackendTerraform(bucket string, stateLocking string, accessKey string, secretKey string, ws *terraformv1.Workspace) string {
-	var region string
-
-	if stateLocking == "" {
-		stateLocking = fmt.Sprintf("%s-locking", bucket)
+// formatTerraformVars renders the backend variables and the Terraform variables of a workspace as a
+// terraform.tfvars.json document. Encoding the values as JSON escapes them and keeps lists, maps, numbers and bools
+// typed. The backend variables are set by the controller and take precedence over variables of the same name, as do
+// the credential variables, which would otherwise override the environment variables they are set by.
+func formatTerraformVars(variableMap map[string]string, ws *terraformv1.Workspace) (string, error) {
+	variables := make(map[string]interface{}, len(variableMap)+len(ws.Spec.TfVars))
+	for k, v := range ws.Spec.TfVars {
+		variables[k] = v
 	}
-
-	if ws.Spec.Region == "cn-north-1" || ws.Spec.Region == "cn-northwest-1" {
-		region = "cn-north-1"
-	} else {
-		region = "us-west-2"
+	for _, name := range credentialVariables {
+		delete(variables, name)
 	}
-	backend := fmt.Sprintf(BackendTemplate, bucket, region, stateLocking, ws.Namespace, accessKey, secretKey)
-	return backend
-}
-
-func formatTerraformVars(variableMap map[string]string, ws *terraformv1.Workspace) string {
-	var terraformVariables, providedVariables, backendVariables string
-
-	// range over backend variables
 	for k, v := range variableMap {
-		backendVariables = fmt.Sprintf(`%s = "%s"`, k, v)
-		terraformVariables = terraformVariables + backendVariables + "\n"
+		variables[k] = v
 	}
 
-	// range over provided variables
-	for k, v := range ws.Spec.TfVars {
-		providedVariables = fmt.Sprintf(`%s = "%s"`, k, v)
-		terraformVariables = terraformVariables + providedVariables + "\n"
+	// Map keys are encoded in sorted order, so the same variables always render the same document
+	data, err := json.MarshalIndent(variables, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode tfVars: %v", err)
 	}
-	return terraformVariables
+	return string(data), nil
 }
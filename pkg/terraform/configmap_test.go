@@ -16,6 +16,8 @@ limitations under the License.
 package terraform
 
 import (
+	"encoding/json"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	terraformv1 "github.com/scipian/terraform-controller/api/v1"
@@ -33,8 +35,6 @@ terraform {
 		region               = "us-west-2"
 		dynamodb_table       = "test-locking"
 		workspace_key_prefix = "namespace"
-		access_key           = "test-key"
-		secret_key           = "test-secret"
 	}
 }
 	`
@@ -46,25 +46,29 @@ terraform {
 		},
 		Spec: terraformv1.WorkspaceSpec{
 			Region: "us-west-2",
-			TfVars: map[string]string{"foo": "bar"},
+			TfVars: map[string]terraformv1.TfVar{"foo": {Raw: []byte(`"bar"`)}},
 		},
 	}
 
 	ws := &testWorkspaceBackend
 
+	backend := &terraformv1.Backend{S3: &terraformv1.S3Backend{
+		Bucket:        "test-backend",
+		Region:        "us-west-2",
+		DynamoDBTable: "test-locking",
+	}}
+
 	variableMap := map[string]string{
 		"network_workspace_namespace": "namespace",
 		"state_bucket_name":           "test-backend",
-		"access_key":                  "test-key",
-		"secret_key":                  "test-secret",
 	}
 
 	Context("Format Terraform Backend", func() {
 		It("Should not be empty", func() {
-			Expect(formatBackendTerraform("test-backend", "test-locking", "test-key", "test-secret", ws)).NotTo(BeEmpty())
+			Expect(formatBackendTerraform(backend, ws)).NotTo(BeEmpty())
 		})
 		It("Should match testBackend", func() {
-			Expect(formatBackendTerraform("test-backend", "test-locking", "test-key", "test-secret", ws)).Should(Equal(testBackend))
+			Expect(formatBackendTerraform(backend, ws)).Should(Equal(testBackend))
 		})
 	})
 
@@ -72,16 +76,99 @@ terraform {
 		It("Should not be empty", func() {
 			Expect(formatTerraformVars(variableMap, ws)).NotTo(BeEmpty())
 		})
+		It("Should keep the type of nested values", func() {
+			typed := ws.DeepCopy()
+			typed.Spec.TfVars = map[string]terraformv1.TfVar{
+				"instance_count": {Raw: []byte(`3`)},
+				"public":         {Raw: []byte(`false`)},
+				"subnets":        {Raw: []byte(`["10.0.1.0/24", "10.0.2.0/24"]`)},
+				"tags":           {Raw: []byte(`{"team": "infra", "ports": [80, 443], "extra": {"enabled": true, "owner": null}}`)},
+			}
+			tfVars, err := formatTerraformVars(map[string]string{}, typed)
+			Expect(err).NotTo(HaveOccurred())
+
+			var decoded map[string]interface{}
+			Expect(json.Unmarshal([]byte(tfVars), &decoded)).To(Succeed())
+			Expect(decoded).Should(Equal(map[string]interface{}{
+				"instance_count": float64(3),
+				"public":         false,
+				"subnets":        []interface{}{"10.0.1.0/24", "10.0.2.0/24"},
+				"tags": map[string]interface{}{
+					"team":  "infra",
+					"ports": []interface{}{float64(80), float64(443)},
+					"extra": map[string]interface{}{"enabled": true, "owner": nil},
+				},
+			}))
+		})
+		It("Should escape quotes and newlines", func() {
+			escaped := ws.DeepCopy()
+			escaped.Spec.TfVars = map[string]terraformv1.TfVar{
+				"user_data": {Raw: []byte(`"#!/bin/bash\necho \"}\" > /tmp/out"`)},
+			}
+			tfVars, err := formatTerraformVars(map[string]string{"secret_key": "a\"b\nc = \"d"}, escaped)
+			Expect(err).NotTo(HaveOccurred())
+
+			var decoded map[string]string
+			Expect(json.Unmarshal([]byte(tfVars), &decoded)).To(Succeed())
+			Expect(decoded).Should(Equal(map[string]string{
+				"user_data":  "#!/bin/bash\necho \"}\" > /tmp/out",
+				"secret_key": "a\"b\nc = \"d",
+			}))
+		})
+		It("Should give backend variables precedence", func() {
+			overridden := ws.DeepCopy()
+			overridden.Spec.TfVars = map[string]terraformv1.TfVar{"state_bucket_name": {Raw: []byte(`"other"`)}}
+			tfVars, err := formatTerraformVars(variableMap, overridden)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tfVars).Should(ContainSubstring(`"state_bucket_name": "test-backend"`))
+			Expect(tfVars).ShouldNot(ContainSubstring("other"))
+		})
+		It("Should leave credential variables to the environment", func() {
+			overridden := ws.DeepCopy()
+			overridden.Spec.TfVars = map[string]terraformv1.TfVar{"access_key": {Raw: []byte(`"other"`)}}
+			tfVars, err := formatTerraformVars(variableMap, overridden)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tfVars).ShouldNot(ContainSubstring("access_key"))
+		})
+		It("Should render the same document for the same variables", func() {
+			first, err := formatTerraformVars(variableMap, ws)
+			Expect(err).NotTo(HaveOccurred())
+			for i := 0; i < 10; i++ {
+				Expect(formatTerraformVars(variableMap, ws)).Should(Equal(first))
+			}
+		})
+		It("Should fail on values that are not JSON", func() {
+			invalid := ws.DeepCopy()
+			invalid.Spec.TfVars = map[string]terraformv1.TfVar{"foo": {Raw: []byte(`bar`)}}
+			_, err := formatTerraformVars(variableMap, invalid)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("TfVar", func() {
+		It("Should round trip any JSON value", func() {
+			var tfVars map[string]terraformv1.TfVar
+			Expect(json.Unmarshal([]byte(`{"a":"b","c":[1,{"d":null}],"e":1.5}`), &tfVars)).To(Succeed())
+			Expect(string(tfVars["c"].Raw)).Should(Equal(`[1,{"d":null}]`))
+			data, err := json.Marshal(tfVars)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).Should(Equal(`{"a":"b","c":[1,{"d":null}],"e":1.5}`))
+		})
 	})
 
 	Context("Create configmap", func() {
 		It("Should contain expected values", func() {
 			key := types.NamespacedName{Namespace: "bar", Name: "foo"}
-			configMap := CreateConfigMap(key, "test-key", "test-secret", ws)
+			configMap, err := CreateConfigMap(key, backend, ws)
+			Expect(err).NotTo(HaveOccurred())
 			Expect(configMap.Name).Should(Equal("foo"))
 			Expect(configMap.Namespace).Should(Equal("bar"))
 			Expect(configMap.Data).Should(HaveKey("backend-tf"))
-			Expect(configMap.Data).Should(HaveKey("terraform-tfvars"))
+			Expect(configMap.Data).Should(HaveKey("terraform-tfvars-json"))
+			for _, value := range configMap.Data {
+				Expect(value).ShouldNot(ContainSubstring("access_key"))
+				Expect(value).ShouldNot(ContainSubstring("secret_key"))
+			}
 		})
 	})
 })
@@ -0,0 +1,80 @@
+package terraform
+
+import (
+	"fmt"
+
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// AccessKeyVariable is the Terraform variable, and the key of the credentials Secret, holding the access key of
+	// the Scipian IAM credentials
+	AccessKeyVariable = "access_key"
+
+	// SecretKeyVariable is the Terraform variable, and the key of the credentials Secret, holding the secret key of
+	// the Scipian IAM credentials
+	SecretKeyVariable = "secret_key"
+)
+
+// credentialVariables are the Terraform variables set from the credentials Secret
+var credentialVariables = []string{AccessKeyVariable, SecretKeyVariable}
+
+// CredentialsSecretName returns the name of the Secret holding the Scipian IAM credentials of a job
+func CredentialsSecretName(jobName string) string {
+	return fmt.Sprintf("%s-credentials", jobName)
+}
+
+// AddCredentials puts the Scipian IAM credentials into a Secret, and hands them to Terraform through the environment
+// of the job instead of its ConfigMap: as the access_key and secret_key variables, and for s3 backends as
+// -backend-config arguments of terraform init. It returns the Secret to create along with the job, or nil without
+// credentials, in which case Terraform authenticates with the identity of the pod.
+func AddCredentials(job *batchv1.Job, backend *terraformv1.Backend, accessKey string, secretKey string) *corev1.Secret {
+	if accessKey == "" && secretKey == "" {
+		return nil
+	}
+	secret := &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      CredentialsSecretName(job.Name),
+			Namespace: job.Namespace,
+			Labels:    make(map[string]string),
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			AccessKeyVariable: []byte(accessKey),
+			SecretKeyVariable: []byte(secretKey),
+		},
+	}
+
+	var env []corev1.EnvVar
+	for _, name := range credentialVariables {
+		env = append(env, corev1.EnvVar{
+			Name: "TF_VAR_" + name,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: secret.Name},
+					Key:                  name,
+				},
+			},
+		})
+	}
+	// Kubernetes expands the references to the variables above, so the credentials only exist in the container
+	if backend.S3 != nil {
+		env = append(env, corev1.EnvVar{
+			Name: "TF_CLI_ARGS_init",
+			Value: fmt.Sprintf("-backend-config=%s=$(TF_VAR_%s) -backend-config=%s=$(TF_VAR_%s)",
+				AccessKeyVariable, AccessKeyVariable, SecretKeyVariable, SecretKeyVariable),
+		})
+	}
+	podSpec := &job.Spec.Template.Spec
+	for i := range podSpec.Containers {
+		podSpec.Containers[i].Env = append(podSpec.Containers[i].Env, env...)
+	}
+	return secret
+}
@@ -0,0 +1,68 @@
+package terraform
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	"github.com/scipian/terraform-controller/pkg/core"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("Credentials", func() {
+	key := types.NamespacedName{Namespace: "team-a", Name: "vpc-run"}
+	ws := &terraformv1.Workspace{
+		ObjectMeta: metav1.ObjectMeta{Name: "vpc", Namespace: "team-a"},
+		Spec:       terraformv1.WorkspaceSpec{Image: "test-image", WorkingDir: "/test", Secret: "vpc-creds"},
+	}
+
+	secretEnv := func(name string, key string) corev1.EnvVar {
+		return corev1.EnvVar{
+			Name: name,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "vpc-run-credentials"},
+					Key:                  key,
+				},
+			},
+		}
+	}
+
+	It("Should keep the credentials in a Secret of the job", func() {
+		job := CreateJob(key, core.TFPlan, ws, false)
+		backend := &terraformv1.Backend{S3: &terraformv1.S3Backend{Bucket: "state", Region: "us-west-2"}}
+		secret := AddCredentials(job, backend, "test-key", "test-secret")
+		Expect(secret.Name).Should(Equal("vpc-run-credentials"))
+		Expect(secret.Namespace).Should(Equal("team-a"))
+		Expect(secret.Data).Should(Equal(map[string][]byte{
+			"access_key": []byte("test-key"),
+			"secret_key": []byte("test-secret"),
+		}))
+
+		env := job.Spec.Template.Spec.Containers[0].Env
+		Expect(env).Should(ContainElement(secretEnv("TF_VAR_access_key", "access_key")))
+		Expect(env).Should(ContainElement(secretEnv("TF_VAR_secret_key", "secret_key")))
+		Expect(env[len(env)-1]).Should(Equal(corev1.EnvVar{
+			Name:  "TF_CLI_ARGS_init",
+			Value: "-backend-config=access_key=$(TF_VAR_access_key) -backend-config=secret_key=$(TF_VAR_secret_key)",
+		}))
+		// The credentials of the workspace itself are still read from its own Secret
+		Expect(env[0].ValueFrom.SecretKeyRef.Name).Should(Equal("vpc-creds"))
+	})
+
+	It("Should only configure s3 backends", func() {
+		job := CreateJob(key, core.TFPlan, ws, false)
+		AddCredentials(job, &terraformv1.Backend{Kubernetes: &terraformv1.KubernetesBackend{}}, "test-key", "test-secret")
+		for _, env := range job.Spec.Template.Spec.Containers[0].Env {
+			Expect(env.Name).ShouldNot(Equal("TF_CLI_ARGS_init"))
+		}
+	})
+	It("Should leave Terraform to the identity of the pod without credentials", func() {
+		job := CreateJob(key, core.TFPlan, ws, false)
+		expected := job.DeepCopy()
+		secret := AddCredentials(job, &terraformv1.Backend{S3: &terraformv1.S3Backend{Bucket: "state"}}, "", "")
+		Expect(secret).Should(BeNil())
+		Expect(job).Should(Equal(expected))
+	})
+})
@@ -18,17 +18,19 @@ var (
 
 // +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
 
-// CreateJob starts a Kubernetes Job that runs Terraform on a given set of files
+// CreateJob starts a Kubernetes Job that runs Terraform on a given set of files. The files of workspaces with a
+// source are fetched by an init container first.
 func CreateJob(key types.NamespacedName, tfCmd string, ws *terraformv1.Workspace, pullAlways bool) *batchv1.Job {
 	var pullPolicy corev1.PullPolicy
-	terraformCommand := fmt.Sprintf(tfCmd, ws.Spec.WorkingDir, ws.Name)
+	workingDir := SourceWorkingDir(ws)
+	terraformCommand := fmt.Sprintf(tfCmd, workingDir, ws.Name)
 	if pullAlways {
 		pullPolicy = corev1.PullPolicy(corev1.PullAlways)
 	} else {
 		pullPolicy = corev1.PullPolicy(corev1.PullIfNotPresent)
 	}
 
-	return &batchv1.Job{
+	job := &batchv1.Job{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "Job",
 			APIVersion: "batch/v1",
@@ -52,7 +54,7 @@ func CreateJob(key types.NamespacedName, tfCmd string, ws *terraformv1.Workspace
 							Image:      ws.Spec.Image,
 							Command:    []string{"/bin/ash"},
 							Args:       []string{"-c", terraformCommand},
-							WorkingDir: ws.Spec.WorkingDir,
+							WorkingDir: workingDir,
 							SecurityContext: &corev1.SecurityContext{
 								Privileged: &falseVal,
 							},
@@ -66,7 +68,8 @@ func CreateJob(key types.NamespacedName, tfCmd string, ws *terraformv1.Workspace
 							},
 						},
 					},
-					RestartPolicy: corev1.RestartPolicyNever,
+					RestartPolicy:      corev1.RestartPolicyNever,
+					ServiceAccountName: ws.Spec.ServiceAccountName,
 					Volumes: []corev1.Volume{
 						{
 							Name: "config-map",
@@ -77,8 +80,8 @@ func CreateJob(key types.NamespacedName, tfCmd string, ws *terraformv1.Workspace
 									},
 									Items: []corev1.KeyToPath{
 										{
-											Key:  "terraform-tfvars",
-											Path: "terraform.tfvars",
+											Key:  TfVarsKey,
+											Path: "terraform.tfvars.json",
 										},
 										{
 											Key:  "backend-tf",
@@ -95,32 +98,39 @@ func CreateJob(key types.NamespacedName, tfCmd string, ws *terraformv1.Workspace
 			},
 		},
 	}
+	addSource(job, ws)
+	return job
 }
 
+// getEnv returns the environment of the Terraform container. The AWS credentials of the Secret of the workspace are
+// left out when it has none, and the providers get credentials from the identity of the pod instead.
 func getEnv(ws *terraformv1.Workspace) []corev1.EnvVar {
-	env := []corev1.EnvVar{
-		{
-			Name: "AWS_ACCESS_KEY_ID",
-			ValueFrom: &corev1.EnvVarSource{
-				SecretKeyRef: &corev1.SecretKeySelector{
-					LocalObjectReference: corev1.LocalObjectReference{
-						Name: ws.Spec.Secret,
+	var env []corev1.EnvVar
+	if ws.Spec.Secret != "" {
+		env = []corev1.EnvVar{
+			{
+				Name: "AWS_ACCESS_KEY_ID",
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{
+							Name: ws.Spec.Secret,
+						},
+						Key: "aws_access_key_id",
 					},
-					Key: "aws_access_key_id",
 				},
 			},
-		},
-		{
-			Name: "AWS_SECRET_ACCESS_KEY",
-			ValueFrom: &corev1.EnvVarSource{
-				SecretKeyRef: &corev1.SecretKeySelector{
-					LocalObjectReference: corev1.LocalObjectReference{
-						Name: ws.Spec.Secret,
+			{
+				Name: "AWS_SECRET_ACCESS_KEY",
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{
+							Name: ws.Spec.Secret,
+						},
+						Key: "aws_secret_access_key",
 					},
-					Key: "aws_secret_access_key",
 				},
 			},
-		},
+		}
 	}
 	for k, v := range ws.Spec.EnvVars {
 		env = append(env, corev1.EnvVar{
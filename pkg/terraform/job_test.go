@@ -118,8 +118,8 @@ var _ = Describe("Job", func() {
 									},
 									Items: []corev1.KeyToPath{
 										{
-											Key:  "terraform-tfvars",
-											Path: "terraform.tfvars",
+											Key:  "terraform-tfvars-json",
+											Path: "terraform.tfvars.json",
 										},
 										{
 											Key:  "backend-tf",
@@ -187,8 +187,8 @@ var _ = Describe("Job", func() {
 									},
 									Items: []corev1.KeyToPath{
 										{
-											Key:  "terraform-tfvars",
-											Path: "terraform.tfvars",
+											Key:  "terraform-tfvars-json",
+											Path: "terraform.tfvars.json",
 										},
 										{
 											Key:  "backend-tf",
@@ -213,6 +213,17 @@ var _ = Describe("Job", func() {
 			Expect(env).NotTo(BeEmpty())
 			Expect(env).Should(Equal(desiredTestEnvVar))
 		})
+
+		It("Should leave out the AWS credentials of workspaces without a Secret", func() {
+			ws := desiredTestWorkspaceForJob.DeepCopy()
+			ws.Spec.Secret = ""
+			ws.Spec.ServiceAccountName = "terraform"
+			for _, env := range getEnv(ws) {
+				Expect(env.Name).ShouldNot(HavePrefix("AWS_"))
+			}
+			job := CreateJob(key, tfCommandTemplate, ws, false)
+			Expect(job.Spec.Template.Spec.ServiceAccountName).Should(Equal("terraform"))
+		})
 	})
 	Context("Create job", func() {
 		It("Should create job object", func() {
@@ -0,0 +1,72 @@
+package terraform
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// lockErrorMessage is printed by Terraform when another operation holds the state lock
+const lockErrorMessage = "Error acquiring the state lock"
+
+// lockCreatedLayout is how Terraform prints when a lock was created
+const lockCreatedLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+var colorCodes = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// LockFromLogs reports whether the Terraform Job the logs are from failed to acquire the state lock, and returns
+// the lock holder Terraform printed along with the error, or nil if it printed none
+func LockFromLogs(logs string) (*terraformv1.StateLock, bool) {
+	logs = colorCodes.ReplaceAllString(logs, "")
+	start := strings.LastIndex(logs, lockErrorMessage)
+	if start < 0 {
+		return nil, false
+	}
+
+	var lock *terraformv1.StateLock
+	inLockInfo := false
+	for _, line := range strings.Split(logs[start:], "\n") {
+		// Terraform 0.15 and later print errors in a box
+		line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "│"))
+		if line == "Lock Info:" {
+			lock = &terraformv1.StateLock{}
+			inLockInfo = true
+			continue
+		}
+		if !inLockInfo {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			break
+		}
+		value := strings.TrimSpace(parts[1])
+		switch parts[0] {
+		case "ID":
+			lock.ID = value
+		case "Path":
+			lock.Path = value
+		case "Operation":
+			lock.Operation = value
+		case "Who":
+			lock.Who = value
+		case "Version":
+			lock.Version = value
+		case "Created":
+			if created, err := time.Parse(lockCreatedLayout, value); err == nil {
+				createdTime := metav1.NewTime(created)
+				lock.Created = &createdTime
+			}
+		default:
+			// Info, which can span lines, is the last field of the lock info
+			inLockInfo = false
+		}
+	}
+	if lock == nil || lock.ID == "" {
+		return nil, true
+	}
+	return lock, true
+}
@@ -0,0 +1,60 @@
+package terraform
+
+import (
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Lock", func() {
+
+	lockInfo := "Lock Info:\n" +
+		"  ID:        5f3c2b9e-0c1d-4f7a-9a3e-2d1b4c5e6f70\n" +
+		"  Path:      state/team-a/vpc/terraform.tfstate\n" +
+		"  Operation: OperationTypeApply\n" +
+		"  Who:       root@vpc-run-abcde\n" +
+		"  Version:   0.12.29\n" +
+		"  Created:   2020-08-04 16:21:50.123456789 +0000 UTC\n" +
+		"  Info:      \n" +
+		"\n" +
+		"Terraform acquires a state lock to protect the state from being written\n" +
+		"by multiple users at the same time.\n"
+
+	It("Should read the lock holder Terraform 0.12 prints", func() {
+		logs := "Initializing the backend...\n" +
+			"\x1b[31m\x1b[1m\x1b[31mError: \x1b[0m\x1b[0m\x1b[1mError locking state: Error acquiring the state lock: " +
+			"ConditionalCheckFailedException: The conditional request failed\n" + lockInfo
+
+		lock, locked := LockFromLogs(logs)
+		Expect(locked).To(BeTrue())
+		Expect(lock.ID).To(Equal("5f3c2b9e-0c1d-4f7a-9a3e-2d1b4c5e6f70"))
+		Expect(lock.Path).To(Equal("state/team-a/vpc/terraform.tfstate"))
+		Expect(lock.Operation).To(Equal("OperationTypeApply"))
+		Expect(lock.Who).To(Equal("root@vpc-run-abcde"))
+		Expect(lock.Version).To(Equal("0.12.29"))
+		Expect(lock.Created.Time.Equal(time.Date(2020, 8, 4, 16, 21, 50, 123456789, time.UTC))).To(BeTrue())
+	})
+
+	It("Should read the lock holder from error boxes", func() {
+		logs := "╷\n│ Error: Error acquiring the state lock\n│ \n│ Error message: ConditionalCheckFailedException\n│ " +
+			strings.Replace(lockInfo, "\n", "\n│ ", -1) + "╵\n"
+
+		lock, locked := LockFromLogs(logs)
+		Expect(locked).To(BeTrue())
+		Expect(lock.ID).To(Equal("5f3c2b9e-0c1d-4f7a-9a3e-2d1b4c5e6f70"))
+		Expect(lock.Who).To(Equal("root@vpc-run-abcde"))
+	})
+
+	It("Should recognize lock errors without lock info", func() {
+		lock, locked := LockFromLogs("Error: Error acquiring the state lock: HTTP remote state endpoint invalid auth\n")
+		Expect(locked).To(BeTrue())
+		Expect(lock).To(BeNil())
+	})
+
+	It("Should not find locks in other failures", func() {
+		_, locked := LockFromLogs("Error: Invalid reference\n")
+		Expect(locked).To(BeFalse())
+	})
+})
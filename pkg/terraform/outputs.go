@@ -0,0 +1,78 @@
+package terraform
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+)
+
+// Output is a Terraform output, in the format printed by `terraform output -json` and stored in the state
+type Output struct {
+	Sensitive bool            `json:"sensitive"`
+	Type      json.RawMessage `json:"type,omitempty"`
+	Value     json.RawMessage `json:"value"`
+}
+
+// ParseOutputs returns the outputs recorded in a Terraform state
+func ParseOutputs(state string) (map[string]Output, error) {
+	outputs := map[string]Output{}
+	// A workspace that was never applied has no state yet
+	if strings.TrimSpace(state) == "" {
+		return outputs, nil
+	}
+	tfState := &struct {
+		Outputs map[string]Output `json:"outputs"`
+	}{}
+	if err := json.Unmarshal([]byte(state), tfState); err != nil {
+		return nil, fmt.Errorf("failed to parse tfstate: %v", err)
+	}
+	for name, output := range tfState.Outputs {
+		outputs[name] = output
+	}
+	return outputs, nil
+}
+
+// FormatOutputs splits outputs into the data of the Secret and the ConfigMap of the given sink, naming each key
+// after sink.Keys or the output name. Strings are stored as they are and other values as JSON. Sensitive outputs
+// are only ever stored in the Secret, and are dropped when the sink has no Secret.
+func FormatOutputs(outputs map[string]Output, sink *terraformv1.OutputsSink) (secretData map[string]string, configMapData map[string]string, err error) {
+	secretData = map[string]string{}
+	configMapData = map[string]string{}
+
+	for name, output := range outputs {
+		key := name
+		if k, ok := sink.Keys[name]; ok && k != "" {
+			key = k
+		}
+		value, err := formatOutputValue(output.Value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to format output %s: %v", name, err)
+		}
+		switch {
+		case sink.ConfigMapName != "" && !output.Sensitive:
+			configMapData[key] = value
+		case sink.SecretName != "":
+			secretData[key] = value
+		}
+	}
+	return secretData, configMapData, nil
+}
+
+func formatOutputValue(raw json.RawMessage) (string, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+	// Lists, maps, numbers and booleans are kept as compact JSON
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", err
+	}
+	formatted, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(formatted), nil
+}
@@ -0,0 +1,77 @@
+package terraform
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+)
+
+var _ = Describe("Outputs", func() {
+
+	testState := `{
+  "version": 4,
+  "terraform_version": "0.12.24",
+  "serial": 3,
+  "outputs": {
+    "vpc_id": {"value": "vpc-123", "type": "string"},
+    "subnet_ids": {"value": ["subnet-1", "subnet-2"], "type": ["list", "string"]},
+    "port": {"value": 5432, "type": "number"},
+    "db_password": {"value": "hunter2", "type": "string", "sensitive": true}
+  },
+  "resources": []
+}`
+
+	Context("Parse outputs", func() {
+		It("Should parse outputs from the state", func() {
+			outputs, err := ParseOutputs(testState)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(outputs).Should(HaveLen(4))
+			Expect(outputs["db_password"].Sensitive).Should(BeTrue())
+			Expect(outputs["vpc_id"].Sensitive).Should(BeFalse())
+		})
+		It("Should return no outputs for an empty state", func() {
+			outputs, err := ParseOutputs("")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(outputs).Should(BeEmpty())
+		})
+		It("Should fail on an invalid state", func() {
+			_, err := ParseOutputs("Test data")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Format outputs", func() {
+		outputs, _ := ParseOutputs(testState)
+
+		It("Should keep sensitive outputs out of the ConfigMap", func() {
+			sink := &terraformv1.OutputsSink{
+				SecretName:    "secret",
+				ConfigMapName: "config",
+				Keys:          map[string]string{"vpc_id": "VPC_ID"},
+			}
+			secretData, configMapData, err := FormatOutputs(outputs, sink)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(secretData).Should(Equal(map[string]string{"db_password": "hunter2"}))
+			Expect(configMapData).Should(Equal(map[string]string{
+				"VPC_ID":     "vpc-123",
+				"subnet_ids": `["subnet-1","subnet-2"]`,
+				"port":       "5432",
+			}))
+		})
+		It("Should store every output in the Secret when there is no ConfigMap", func() {
+			sink := &terraformv1.OutputsSink{SecretName: "secret"}
+			secretData, configMapData, err := FormatOutputs(outputs, sink)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(secretData).Should(HaveLen(4))
+			Expect(configMapData).Should(BeEmpty())
+		})
+		It("Should drop sensitive outputs when there is no Secret", func() {
+			sink := &terraformv1.OutputsSink{ConfigMapName: "config"}
+			secretData, configMapData, err := FormatOutputs(outputs, sink)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(secretData).Should(BeEmpty())
+			Expect(configMapData).Should(HaveLen(3))
+			Expect(configMapData).ShouldNot(HaveKey("db_password"))
+		})
+	})
+})
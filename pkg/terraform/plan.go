@@ -0,0 +1,109 @@
+package terraform
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+)
+
+// planJSONPrefix is how every document printed by `terraform show -json` starts
+const planJSONPrefix = `{"format_version"`
+
+// plan is the subset of the `terraform show -json` plan representation needed to summarize it. The values of the
+// plan, sensitive ones included, are never decoded.
+type plan struct {
+	ResourceChanges []resourceChange `json:"resource_changes"`
+	ResourceDrift   []resourceChange `json:"resource_drift"`
+}
+
+type resourceChange struct {
+	Address string `json:"address"`
+	Change  struct {
+		Actions []string `json:"actions"`
+	} `json:"change"`
+}
+
+// PlanFromLogs returns the last plan printed by `terraform show -json` in the logs of a Terraform Job. The plan can
+// contain sensitive values, so it must only be reduced with SummarizePlan or DriftedAddresses, and never stored.
+func PlanFromLogs(logs string) ([]byte, error) {
+	lines := strings.Split(logs, "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(line, planJSONPrefix) {
+			return []byte(line), nil
+		}
+	}
+	return nil, fmt.Errorf("no plan found in logs")
+}
+
+// StripPlans removes the plans printed by `terraform show -json` from the logs of a Terraform Job, which are only meant
+// for the controller and can contain sensitive values
+func StripPlans(logs string) string {
+	lines := strings.SplitAfter(logs, "\n")
+	stripped := lines[:0]
+	for _, line := range lines {
+		if !strings.HasPrefix(strings.TrimSpace(line), planJSONPrefix) {
+			stripped = append(stripped, line)
+		}
+	}
+	return strings.Join(stripped, "")
+}
+
+// SummarizePlan counts the resources a plan adds, changes and destroys, the same way `terraform plan` does
+func SummarizePlan(planJSON []byte) (*terraformv1.PlanSummary, error) {
+	p := &plan{}
+	if err := json.Unmarshal(planJSON, p); err != nil {
+		return nil, fmt.Errorf("failed to parse plan: %v", err)
+	}
+
+	summary := &terraformv1.PlanSummary{}
+	for _, rc := range p.ResourceChanges {
+		changed := false
+		for _, action := range rc.Change.Actions {
+			switch action {
+			case "create":
+				summary.Add++
+				changed = true
+			case "update":
+				summary.Change++
+				changed = true
+			case "delete":
+				summary.Destroy++
+				changed = true
+			}
+		}
+		// no-op and read actions do not change anything
+		if changed {
+			summary.Addresses = append(summary.Addresses, rc.Address)
+		}
+	}
+	return summary, nil
+}
+
+// DriftedAddresses returns the addresses of the resources that were changed outside of Terraform, followed by those
+// the plan would change
+func DriftedAddresses(planJSON []byte) ([]string, error) {
+	p := &plan{}
+	if err := json.Unmarshal(planJSON, p); err != nil {
+		return nil, fmt.Errorf("failed to parse plan: %v", err)
+	}
+	summary, err := SummarizePlan(planJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	var addresses []string
+	seen := map[string]bool{}
+	for _, rc := range p.ResourceDrift {
+		addresses = append(addresses, rc.Address)
+		seen[rc.Address] = true
+	}
+	for _, address := range summary.Addresses {
+		if !seen[address] {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses, nil
+}
@@ -0,0 +1,97 @@
+package terraform
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	"github.com/scipian/terraform-controller/pkg/core"
+)
+
+var _ = Describe("Plan", func() {
+
+	testPlan := `{"format_version":"0.1","terraform_version":"0.12.24","resource_changes":[` +
+		`{"address":"aws_instance.new","change":{"actions":["create"],"after":{"password":"hunter2"},"after_sensitive":{"password":true}}},` +
+		`{"address":"aws_instance.updated","change":{"actions":["update"]}},` +
+		`{"address":"aws_instance.replaced","change":{"actions":["delete","create"]}},` +
+		`{"address":"aws_instance.removed","change":{"actions":["delete"]}},` +
+		`{"address":"aws_instance.unchanged","change":{"actions":["no-op"]}},` +
+		`{"address":"data.aws_ami.ubuntu","change":{"actions":["read"]}}],"resource_drift":[]}`
+
+	testLogs := "Initializing the backend...\n" +
+		"Plan: 2 to add, 1 to change, 2 to destroy.\n" +
+		testPlan + "\n" +
+		"aws_instance.new: Creating...\n" +
+		"Apply complete! Resources: 2 added, 1 changed, 2 destroyed.\n"
+
+	Context("Plan from logs", func() {
+		It("Should find the plan among other output", func() {
+			planJSON, err := PlanFromLogs(testLogs)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(planJSON)).Should(Equal(testPlan))
+		})
+		It("Should fail without a plan", func() {
+			_, err := PlanFromLogs("Error: No configuration files\n")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Show plan", func() {
+		It("Should show the plan with Terraform alone", func() {
+			for _, command := range []string{core.TFPlan, core.TFPlanOnly, core.TFDriftCheck} {
+				shows := strings.Split(command, "terraform show -json ")[1:]
+				Expect(shows).Should(HaveLen(1))
+				Expect(shows[0]).ShouldNot(ContainSubstring("|"))
+			}
+		})
+	})
+
+	Context("Strip plans", func() {
+		It("Should remove the plan and keep the rest of the output", func() {
+			Expect(StripPlans(testLogs)).Should(Equal("Initializing the backend...\n" +
+				"Plan: 2 to add, 1 to change, 2 to destroy.\n" +
+				"aws_instance.new: Creating...\n" +
+				"Apply complete! Resources: 2 added, 1 changed, 2 destroyed.\n"))
+		})
+	})
+
+	Context("Summarize plan", func() {
+		It("Should count changes like terraform plan does", func() {
+			summary, err := SummarizePlan([]byte(testPlan))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fmt.Sprint(summary)).ShouldNot(ContainSubstring("hunter2"))
+			Expect(summary).Should(Equal(&terraformv1.PlanSummary{
+				Add:     2,
+				Change:  1,
+				Destroy: 2,
+				Addresses: []string{
+					"aws_instance.new",
+					"aws_instance.updated",
+					"aws_instance.replaced",
+					"aws_instance.removed",
+				},
+			}))
+		})
+		It("Should fail on invalid JSON", func() {
+			_, err := SummarizePlan([]byte("{"))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Drifted addresses", func() {
+		It("Should list drifted resources before planned changes", func() {
+			driftPlan := `{"format_version":"0.1","resource_changes":[` +
+				`{"address":"aws_instance.updated","change":{"actions":["update"]}},` +
+				`{"address":"aws_instance.new","change":{"actions":["create"]}},` +
+				`{"address":"aws_instance.unchanged","change":{"actions":["no-op"]}}],` +
+				`"resource_drift":[` +
+				`{"address":"aws_security_group.web","change":{"actions":["update"]}},` +
+				`{"address":"aws_instance.updated","change":{"actions":["update"]}}]}`
+			addresses, err := DriftedAddresses([]byte(driftPlan))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(addresses).Should(Equal([]string{"aws_security_group.web", "aws_instance.updated", "aws_instance.new"}))
+		})
+	})
+})
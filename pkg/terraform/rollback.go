@@ -0,0 +1,66 @@
+package terraform
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+
+	"github.com/scipian/terraform-controller/pkg/core"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// RollbackKey is the Secret key holding the gzipped state a rollback Run pushes
+const RollbackKey = "tfstate.gz"
+
+// RollbackSecretName returns the name of the Secret holding the state a rollback Run pushes
+func RollbackSecretName(runName string) string {
+	return fmt.Sprintf("%s-rollback", runName)
+}
+
+// CreateRollbackSecret creates the Secret holding the state a rollback Run pushes, gzipped so larger states fit
+func CreateRollbackSecret(key types.NamespacedName, state string) (*corev1.Secret, error) {
+	buffer := &bytes.Buffer{}
+	writer := gzip.NewWriter(buffer)
+	if _, err := writer.Write([]byte(state)); err != nil {
+		return nil, fmt.Errorf("failed to compress state: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress state: %v", err)
+	}
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      RollbackSecretName(key.Name),
+			Namespace: key.Namespace,
+			Labels:    make(map[string]string),
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{RollbackKey: buffer.Bytes()},
+	}, nil
+}
+
+// AddRollbackState mounts the given rollback Secret into every container of the Job at core.RollbackDir
+func AddRollbackState(job *batchv1.Job, secretName string) {
+	podSpec := &job.Spec.Template.Spec
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: "rollback",
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: secretName,
+			},
+		},
+	})
+	for i := range podSpec.Containers {
+		podSpec.Containers[i].VolumeMounts = append(podSpec.Containers[i].VolumeMounts, corev1.VolumeMount{
+			Name:      "rollback",
+			MountPath: core.RollbackDir,
+			ReadOnly:  true,
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package terraform
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	"github.com/scipian/terraform-controller/pkg/core"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("Rollback", func() {
+	key := types.NamespacedName{Namespace: "team-a", Name: "vpc-restore"}
+	ws := &terraformv1.Workspace{
+		ObjectMeta: metav1.ObjectMeta{Name: "vpc", Namespace: "team-a"},
+		Spec:       terraformv1.WorkspaceSpec{Image: "test-image", WorkingDir: "/test"},
+	}
+
+	It("Should keep the state to push gzipped in a Secret", func() {
+		state := `{"version": 4, "serial": 3}`
+		secret, err := CreateRollbackSecret(key, state)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(secret.Name).Should(Equal("vpc-restore-rollback"))
+		Expect(secret.Namespace).Should(Equal("team-a"))
+
+		reader, err := gzip.NewReader(bytes.NewReader(secret.Data[RollbackKey]))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ioutil.ReadAll(reader)).Should(Equal([]byte(state)))
+	})
+
+	It("Should push the mounted state over the current state", func() {
+		job := CreateJob(key, core.TFStatePush, ws, false)
+		AddRollbackState(job, RollbackSecretName(key.Name))
+
+		podSpec := job.Spec.Template.Spec
+		Expect(podSpec.Containers[0].Args[1]).Should(Equal("cp /opt/meta/* /test && terraform init -force-copy && " +
+			"terraform workspace select vpc && gunzip -c /opt/rollback/tfstate.gz | terraform state push -force -"))
+		Expect(podSpec.Volumes).Should(ContainElement(corev1.Volume{
+			Name: "rollback",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: "vpc-restore-rollback"},
+			},
+		}))
+		Expect(podSpec.Containers[0].VolumeMounts).Should(ContainElement(corev1.VolumeMount{
+			Name:      "rollback",
+			MountPath: core.RollbackDir,
+			ReadOnly:  true,
+		}))
+	})
+})
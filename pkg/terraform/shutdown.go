@@ -0,0 +1,55 @@
+package terraform
+
+import (
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// TerminationGracePeriodSeconds is how long an interrupted Terraform has to release the state lock and write
+// partial state before its pod is killed
+const TerminationGracePeriodSeconds = 300
+
+// interruptTerraform sends Terraform the SIGINT it handles gracefully, and waits for it to exit. The shell running
+// the Terraform command is PID 1 and ignores the SIGTERM sent when the pod is stopped.
+const interruptTerraform = "pkill -INT -x terraform; while pgrep -x terraform > /dev/null; do sleep 1; done"
+
+// AddGracefulShutdown interrupts Terraform before the pod of a job is stopped, whether the job is deleted or runs
+// past its deadline
+func AddGracefulShutdown(job *batchv1.Job) {
+	gracePeriod := int64(TerminationGracePeriodSeconds)
+	podSpec := &job.Spec.Template.Spec
+	podSpec.TerminationGracePeriodSeconds = &gracePeriod
+	for i := range podSpec.Containers {
+		podSpec.Containers[i].Lifecycle = &corev1.Lifecycle{
+			PreStop: &corev1.Handler{
+				Exec: &corev1.ExecAction{Command: []string{"/bin/ash", "-c", interruptTerraform}},
+			},
+		}
+	}
+}
+
+// AddTimeout limits how long a job may be active before its pod is stopped
+func AddTimeout(job *batchv1.Job, timeout time.Duration) {
+	seconds := int64(timeout.Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	job.Spec.ActiveDeadlineSeconds = &seconds
+}
+
+// JobTimedOut reports whether a job failed, or is about to, because it was active past its deadline
+func JobTimedOut(job *batchv1.Job, now time.Time) bool {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == batchv1.JobFailed && condition.Status == corev1.ConditionTrue {
+			return condition.Reason == "DeadlineExceeded"
+		}
+	}
+	// The pod can fail before the job controller records why
+	if job.Spec.ActiveDeadlineSeconds == nil || job.Status.StartTime == nil {
+		return false
+	}
+	deadline := job.Status.StartTime.Add(time.Duration(*job.Spec.ActiveDeadlineSeconds) * time.Second)
+	return !now.Before(deadline)
+}
@@ -0,0 +1,77 @@
+package terraform
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	"github.com/scipian/terraform-controller/pkg/core"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("Shutdown", func() {
+	key := types.NamespacedName{Namespace: "test-namespace", Name: "test-run"}
+	ws := &terraformv1.Workspace{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-ws"},
+		Spec:       terraformv1.WorkspaceSpec{Image: "test-image", WorkingDir: "/test"},
+	}
+
+	Context("Add graceful shutdown", func() {
+		It("Should interrupt terraform before stopping the container", func() {
+			job := CreateJob(key, core.TFPlan, ws, false)
+			AddGracefulShutdown(job)
+
+			podSpec := job.Spec.Template.Spec
+			Expect(*podSpec.TerminationGracePeriodSeconds).Should(Equal(int64(TerminationGracePeriodSeconds)))
+			Expect(podSpec.Containers[0].Lifecycle.PreStop.Exec.Command).Should(Equal([]string{
+				"/bin/ash", "-c", "pkill -INT -x terraform; while pgrep -x terraform > /dev/null; do sleep 1; done",
+			}))
+		})
+	})
+
+	Context("Add timeout", func() {
+		It("Should set the active deadline of the job", func() {
+			job := CreateJob(key, core.TFPlan, ws, false)
+			AddTimeout(job, 90*time.Minute)
+			Expect(*job.Spec.ActiveDeadlineSeconds).Should(Equal(int64(5400)))
+		})
+		It("Should round short timeouts up to a second", func() {
+			job := CreateJob(key, core.TFPlan, ws, false)
+			AddTimeout(job, time.Millisecond)
+			Expect(*job.Spec.ActiveDeadlineSeconds).Should(Equal(int64(1)))
+		})
+	})
+
+	Context("Job timed out", func() {
+		now := time.Now()
+		started := metav1.NewTime(now.Add(-time.Hour))
+
+		It("Should recognise the deadline exceeded condition", func() {
+			job := &batchv1.Job{Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: "DeadlineExceeded"},
+			}}}
+			Expect(JobTimedOut(job, now)).Should(BeTrue())
+		})
+		It("Should not mistake other failures for timeouts", func() {
+			job := &batchv1.Job{Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: "BackoffLimitExceeded"},
+			}}}
+			Expect(JobTimedOut(job, now)).Should(BeFalse())
+		})
+		It("Should compare the start time with the deadline before the job fails", func() {
+			job := CreateJob(key, core.TFPlan, ws, false)
+			job.Status.StartTime = &started
+			Expect(JobTimedOut(job, now)).Should(BeFalse())
+
+			AddTimeout(job, 2*time.Hour)
+			Expect(JobTimedOut(job, now)).Should(BeFalse())
+
+			AddTimeout(job, 30*time.Minute)
+			Expect(JobTimedOut(job, now)).Should(BeTrue())
+		})
+	})
+})
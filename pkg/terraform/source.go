@@ -0,0 +1,125 @@
+package terraform
+
+import (
+	"os"
+	"path"
+	"strings"
+
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// SourceDir is where the Terraform configuration of a workspace with a source is fetched to
+	SourceDir = "/opt/source"
+
+	// SourceContainerName is the name of the init container fetching the source of a workspace
+	SourceContainerName = "fetch-source"
+
+	// DefaultSourceImage is the image fetching sources when SCIPIAN_SOURCE_IMAGE is not set. It needs git, wget, tar
+	// and sha256sum.
+	DefaultSourceImage = "alpine/git:v2.30.2"
+
+	sourceVolumeName = "source"
+)
+
+// fetchGitSource clones the repository and checks out the requested ref, then writes the checked out commit to the
+// termination message of the init container
+const fetchGitSource = `set -e
+git clone --quiet "$SOURCE_URL" "$SOURCE_DIR"
+cd "$SOURCE_DIR"
+if [ -n "$SOURCE_REF" ]; then git checkout --quiet "$SOURCE_REF"; fi
+git rev-parse HEAD > "$REVISION_PATH"`
+
+// fetchHTTPSource downloads and unpacks the tarball, then writes its digest to the termination message of the init
+// container
+const fetchHTTPSource = `set -e
+archive=$(mktemp)
+wget -q -O "$archive" "$SOURCE_URL"
+tar -xzf "$archive" -C "$SOURCE_DIR"
+sha256sum "$archive" | cut -d ' ' -f 1 > "$REVISION_PATH"
+rm -f "$archive"`
+
+// SourceWorkingDir returns the directory Terraform runs in for a workspace
+func SourceWorkingDir(ws *terraformv1.Workspace) string {
+	source := ws.Spec.Source
+	switch {
+	case source == nil:
+		return ws.Spec.WorkingDir
+	case source.Git != nil:
+		return path.Join(SourceDir, source.Git.Path)
+	case source.HTTP != nil:
+		return path.Join(SourceDir, source.HTTP.Path)
+	}
+	return ws.Spec.WorkingDir
+}
+
+// addSource fetches the source of a workspace into a volume shared with the Terraform container, through an init
+// container. Workspaces without a source are left alone.
+func addSource(job *batchv1.Job, ws *terraformv1.Workspace) {
+	script, env := sourceScript(ws.Spec.Source)
+	if script == "" {
+		return
+	}
+	podSpec := &job.Spec.Template.Spec
+
+	sourceImage := os.Getenv("SCIPIAN_SOURCE_IMAGE")
+	if sourceImage == "" {
+		sourceImage = DefaultSourceImage
+	}
+	mount := corev1.VolumeMount{Name: sourceVolumeName, MountPath: SourceDir}
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name:         sourceVolumeName,
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	})
+	podSpec.InitContainers = append(podSpec.InitContainers, corev1.Container{
+		Name:    SourceContainerName,
+		Image:   sourceImage,
+		Command: []string{"/bin/sh"},
+		Args:    []string{"-c", script},
+		Env: append(env,
+			corev1.EnvVar{Name: "SOURCE_DIR", Value: SourceDir},
+			corev1.EnvVar{Name: "REVISION_PATH", Value: corev1.TerminationMessagePathDefault},
+		),
+		SecurityContext: &corev1.SecurityContext{
+			Privileged: &falseVal,
+		},
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		VolumeMounts:    []corev1.VolumeMount{mount},
+	})
+	for i := range podSpec.Containers {
+		podSpec.Containers[i].VolumeMounts = append(podSpec.Containers[i].VolumeMounts, mount)
+	}
+}
+
+// sourceScript returns the script fetching a source and the environment it reads the source from
+func sourceScript(source *terraformv1.Source) (string, []corev1.EnvVar) {
+	switch {
+	case source == nil:
+		return "", nil
+	case source.Git != nil:
+		return fetchGitSource, []corev1.EnvVar{
+			{Name: "SOURCE_URL", Value: source.Git.URL},
+			{Name: "SOURCE_REF", Value: source.Git.Ref},
+		}
+	case source.HTTP != nil:
+		return fetchHTTPSource, []corev1.EnvVar{
+			{Name: "SOURCE_URL", Value: source.HTTP.URL},
+		}
+	}
+	return "", nil
+}
+
+// SourceRevision returns the commit, or archive digest, the init container of a pod fetched, once it has succeeded
+func SourceRevision(pod *corev1.Pod) string {
+	for _, status := range pod.Status.InitContainerStatuses {
+		if status.Name != SourceContainerName || status.State.Terminated == nil {
+			continue
+		}
+		if status.State.Terminated.ExitCode == 0 {
+			return strings.TrimSpace(status.State.Terminated.Message)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,232 @@
+package terraform
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	"github.com/scipian/terraform-controller/pkg/core"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("Source", func() {
+	key := types.NamespacedName{Namespace: "test-namespace", Name: "test-run"}
+	newWorkspace := func(source *terraformv1.Source) *terraformv1.Workspace {
+		return &terraformv1.Workspace{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-ws", Namespace: "test-namespace"},
+			Spec: terraformv1.WorkspaceSpec{
+				Image:      "test-image",
+				WorkingDir: "/test",
+				Source:     source,
+			},
+		}
+	}
+
+	// fetch runs a fetch script the way the init container does, with the source and revision paths under dir
+	fetch := func(script string, env []corev1.EnvVar, dir string) (string, error) {
+		cmd := exec.Command("/bin/sh", "-c", script)
+		cmd.Env = append(os.Environ(), "SOURCE_DIR="+filepath.Join(dir, "source"), "REVISION_PATH="+filepath.Join(dir, "revision"))
+		for _, e := range env {
+			cmd.Env = append(cmd.Env, e.Name+"="+e.Value)
+		}
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return string(output), err
+		}
+		revision, err := ioutil.ReadFile(filepath.Join(dir, "revision"))
+		return strings.TrimSpace(string(revision)), err
+	}
+
+	Context("Create job", func() {
+		It("Should fetch a Git source in an init container", func() {
+			ws := newWorkspace(&terraformv1.Source{Git: &terraformv1.GitSource{
+				URL:  "https://example.com/infra.git",
+				Ref:  "v1.2.0",
+				Path: "modules/vpc",
+			}})
+			job := CreateJob(key, core.TFPlan, ws, false)
+			podSpec := job.Spec.Template.Spec
+
+			Expect(podSpec.InitContainers).Should(HaveLen(1))
+			init := podSpec.InitContainers[0]
+			Expect(init.Name).Should(Equal(SourceContainerName))
+			Expect(init.Image).Should(Equal(DefaultSourceImage))
+			Expect(init.Args).Should(Equal([]string{"-c", fetchGitSource}))
+			Expect(init.Env).Should(ContainElement(corev1.EnvVar{Name: "SOURCE_URL", Value: "https://example.com/infra.git"}))
+			Expect(init.Env).Should(ContainElement(corev1.EnvVar{Name: "SOURCE_REF", Value: "v1.2.0"}))
+			Expect(init.VolumeMounts).Should(Equal([]corev1.VolumeMount{{Name: "source", MountPath: SourceDir}}))
+
+			Expect(podSpec.Volumes).Should(ContainElement(corev1.Volume{
+				Name:         "source",
+				VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+			}))
+			container := podSpec.Containers[0]
+			Expect(container.WorkingDir).Should(Equal("/opt/source/modules/vpc"))
+			Expect(container.Args[1]).Should(HavePrefix("cp /opt/meta/* /opt/source/modules/vpc && "))
+			Expect(container.VolumeMounts).Should(ContainElement(corev1.VolumeMount{Name: "source", MountPath: SourceDir}))
+		})
+
+		It("Should use the configured source image", func() {
+			os.Setenv("SCIPIAN_SOURCE_IMAGE", "registry.example.com/git:latest")
+			defer os.Unsetenv("SCIPIAN_SOURCE_IMAGE")
+			ws := newWorkspace(&terraformv1.Source{HTTP: &terraformv1.HTTPSource{URL: "https://example.com/infra.tar.gz"}})
+			job := CreateJob(key, core.TFPlan, ws, false)
+
+			Expect(job.Spec.Template.Spec.InitContainers[0].Image).Should(Equal("registry.example.com/git:latest"))
+			Expect(job.Spec.Template.Spec.InitContainers[0].Args).Should(Equal([]string{"-c", fetchHTTPSource}))
+			Expect(job.Spec.Template.Spec.Containers[0].WorkingDir).Should(Equal(SourceDir))
+		})
+
+		It("Should not fetch anything without a source", func() {
+			job := CreateJob(key, core.TFPlan, newWorkspace(nil), false)
+
+			Expect(job.Spec.Template.Spec.InitContainers).Should(BeEmpty())
+			Expect(job.Spec.Template.Spec.Volumes).Should(HaveLen(1))
+			Expect(job.Spec.Template.Spec.Containers[0].WorkingDir).Should(Equal("/test"))
+		})
+	})
+
+	Context("Fetch Git source", func() {
+		var dir, repo string
+		var commits map[string]string
+
+		git := func(workTree string, args ...string) string {
+			cmd := exec.Command("git", args...)
+			cmd.Dir = workTree
+			cmd.Env = append(os.Environ(),
+				"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+				"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+			output, err := cmd.CombinedOutput()
+			Expect(err).NotTo(HaveOccurred(), string(output))
+			return strings.TrimSpace(string(output))
+		}
+		commit := func(workTree, file, content string) string {
+			Expect(os.MkdirAll(filepath.Dir(filepath.Join(workTree, file)), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(workTree, file), []byte(content), 0644)).To(Succeed())
+			git(workTree, "add", "-A")
+			git(workTree, "commit", "-q", "-m", file)
+			return git(workTree, "rev-parse", "HEAD")
+		}
+
+		BeforeEach(func() {
+			if _, err := exec.LookPath("git"); err != nil {
+				Skip("git is not installed")
+			}
+			var err error
+			dir, err = ioutil.TempDir("", "source")
+			Expect(err).NotTo(HaveOccurred())
+
+			// A bare repository served over file:// stands in for a Git server
+			repo = filepath.Join(dir, "infra.git")
+			workTree := filepath.Join(dir, "work")
+			git(dir, "init", "-q", "--bare", repo)
+			git(dir, "init", "-q", workTree)
+			git(workTree, "checkout", "-q", "-b", "main")
+			commits = map[string]string{}
+			commits["first"] = commit(workTree, "modules/vpc/main.tf", "# first")
+			git(workTree, "tag", "v1")
+			commits["main"] = commit(workTree, "modules/vpc/main.tf", "# main")
+			git(workTree, "checkout", "-q", "-b", "feature")
+			commits["feature"] = commit(workTree, "modules/vpc/main.tf", "# feature")
+			git(workTree, "push", "-q", "file://"+repo, "main", "feature", "v1")
+			git(repo, "symbolic-ref", "HEAD", "refs/heads/main")
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		for _, c := range []struct{ name, ref, expected string }{
+			{"the default branch", "", "main"},
+			{"a branch", "feature", "feature"},
+			{"a tag", "v1", "first"},
+			{"a commit", "first", "first"},
+		} {
+			c := c
+			It("Should check out "+c.name+" and report its commit", func() {
+				ref := c.ref
+				if ref == "first" {
+					ref = commits["first"]
+				}
+				_, env := sourceScript(&terraformv1.Source{Git: &terraformv1.GitSource{URL: "file://" + repo, Ref: ref}})
+				revision, err := fetch(fetchGitSource, env, dir)
+				Expect(err).NotTo(HaveOccurred(), revision)
+				Expect(revision).Should(Equal(commits[c.expected]))
+
+				content, err := ioutil.ReadFile(filepath.Join(dir, "source", "modules", "vpc", "main.tf"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).Should(Equal("# " + c.expected))
+			})
+		}
+
+		It("Should fail on an unknown ref", func() {
+			_, env := sourceScript(&terraformv1.Source{Git: &terraformv1.GitSource{URL: "file://" + repo, Ref: "missing"}})
+			_, err := fetch(fetchGitSource, env, dir)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Fetch HTTP source", func() {
+		It("Should unpack the archive and report its digest", func() {
+			if _, err := exec.LookPath("wget"); err != nil {
+				Skip("wget is not installed")
+			}
+			dir, err := ioutil.TempDir("", "source")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(dir)
+			Expect(os.Mkdir(filepath.Join(dir, "source"), 0755)).To(Succeed())
+
+			archive := &bytes.Buffer{}
+			gz := gzip.NewWriter(archive)
+			tw := tar.NewWriter(gz)
+			content := []byte(`variable "name" {}`)
+			Expect(tw.WriteHeader(&tar.Header{Name: "infra/main.tf", Mode: 0644, Size: int64(len(content))})).To(Succeed())
+			_, err = tw.Write(content)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tw.Close()).To(Succeed())
+			Expect(gz.Close()).To(Succeed())
+			digest := sha256.Sum256(archive.Bytes())
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.Write(archive.Bytes())
+			}))
+			defer server.Close()
+
+			_, env := sourceScript(&terraformv1.Source{HTTP: &terraformv1.HTTPSource{URL: server.URL + "/infra.tar.gz"}})
+			revision, err := fetch(fetchHTTPSource, env, dir)
+			Expect(err).NotTo(HaveOccurred(), revision)
+			Expect(revision).Should(Equal(hex.EncodeToString(digest[:])))
+
+			unpacked, err := ioutil.ReadFile(filepath.Join(dir, "source", "infra", "main.tf"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(unpacked).Should(Equal(content))
+		})
+	})
+
+	Context("Source revision", func() {
+		It("Should read the termination message of the succeeded init container", func() {
+			pod := &corev1.Pod{Status: corev1.PodStatus{InitContainerStatuses: []corev1.ContainerStatus{{
+				Name:  SourceContainerName,
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Message: "3f2c1e0\n"}},
+			}}}}
+			Expect(SourceRevision(pod)).Should(Equal("3f2c1e0"))
+
+			pod.Status.InitContainerStatuses[0].State.Terminated.ExitCode = 128
+			Expect(SourceRevision(pod)).Should(BeEmpty())
+			Expect(SourceRevision(&corev1.Pod{})).Should(BeEmpty())
+		})
+	})
+})
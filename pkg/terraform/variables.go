@@ -0,0 +1,130 @@
+package terraform
+
+import (
+	"encoding/json"
+	"fmt"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// VariablesKey is the ConfigMap key holding the variables of a Workspace that are not sensitive
+	VariablesKey = "variables-tfvars-json"
+
+	// SensitiveVariablesKey is the Secret key holding the variables of a Workspace read from Secrets
+	SensitiveVariablesKey = "sensitive-tfvars-json"
+
+	// configMapVolumeName is the volume holding the files the Terraform command copies into the working directory
+	configMapVolumeName = "config-map"
+)
+
+// controllerVariables are the Terraform variables set by the controller, through the environment of a job or its
+// terraform.tfvars.json. Variables of a Workspace with these names are dropped, as Terraform would give them
+// precedence from the *.auto.tfvars.json files.
+var controllerVariables = append([]string{NamespaceVariable, StateBucketVariable}, credentialVariables...)
+
+// Variables holds the resolved values of the variables of a Workspace, split by whether they were read from a Secret
+type Variables struct {
+	Plain     map[string]string
+	Sensitive map[string]string
+}
+
+// VariablesSecretName returns the name of the Secret holding the sensitive variables of a job
+func VariablesSecretName(jobName string) string {
+	return fmt.Sprintf("%s-variables", jobName)
+}
+
+// AddVariables writes the plain variables to the ConfigMap of a job and the sensitive ones to a Secret, and adds
+// them to the files the job copies into the working directory as *.auto.tfvars.json files, which Terraform loads
+// automatically. Variables named like those the controller sets are left out. It returns the Secret to create along
+// with the job, or nil when there are no sensitive variables.
+func AddVariables(job *batchv1.Job, configMap *corev1.ConfigMap, variables Variables) (*corev1.Secret, error) {
+	variables = Variables{
+		Plain:     withoutControllerVariables(variables.Plain),
+		Sensitive: withoutControllerVariables(variables.Sensitive),
+	}
+	var configMapSource *corev1.ConfigMapVolumeSource
+	volumes := job.Spec.Template.Spec.Volumes
+	for i := range volumes {
+		if volumes[i].Name == configMapVolumeName && volumes[i].ConfigMap != nil {
+			configMapSource = volumes[i].ConfigMap
+		}
+	}
+	if configMapSource == nil {
+		return nil, fmt.Errorf("job/%s has no %s volume", job.Name, configMapVolumeName)
+	}
+
+	if len(variables.Plain) > 0 {
+		data, err := json.Marshal(variables.Plain)
+		if err != nil {
+			return nil, err
+		}
+		configMap.Data[VariablesKey] = string(data)
+		configMapSource.Items = append(configMapSource.Items, corev1.KeyToPath{
+			Key:  VariablesKey,
+			Path: "variables.auto.tfvars.json",
+		})
+	}
+	if len(variables.Sensitive) == 0 {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(variables.Sensitive)
+	if err != nil {
+		return nil, err
+	}
+	secret := &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      VariablesSecretName(job.Name),
+			Namespace: job.Namespace,
+			Labels:    make(map[string]string),
+		},
+		Data: map[string][]byte{SensitiveVariablesKey: data},
+	}
+
+	// Project the Secret into the same directory as the ConfigMap
+	for i := range volumes {
+		if volumes[i].Name != configMapVolumeName {
+			continue
+		}
+		volumes[i].VolumeSource = corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources: []corev1.VolumeProjection{
+					{ConfigMap: &corev1.ConfigMapProjection{
+						LocalObjectReference: configMapSource.LocalObjectReference,
+						Items:                configMapSource.Items,
+						Optional:             configMapSource.Optional,
+					}},
+					{Secret: &corev1.SecretProjection{
+						LocalObjectReference: corev1.LocalObjectReference{Name: secret.Name},
+						Items: []corev1.KeyToPath{
+							{
+								Key:  SensitiveVariablesKey,
+								Path: "sensitive.auto.tfvars.json",
+							},
+						},
+					}},
+				},
+			},
+		}
+	}
+	return secret, nil
+}
+
+// withoutControllerVariables returns a copy of variables without those named like the controller variables
+func withoutControllerVariables(variables map[string]string) map[string]string {
+	kept := make(map[string]string, len(variables))
+	for name, value := range variables {
+		kept[name] = value
+	}
+	for _, name := range controllerVariables {
+		delete(kept, name)
+	}
+	return kept
+}
@@ -0,0 +1,94 @@
+package terraform
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	"github.com/scipian/terraform-controller/pkg/core"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("Variables", func() {
+	key := types.NamespacedName{Namespace: "test-namespace", Name: "test-run"}
+	backend := &terraformv1.Backend{Kubernetes: &terraformv1.KubernetesBackend{SecretSuffix: "state"}}
+	ws := &terraformv1.Workspace{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-ws", Namespace: "test-namespace"},
+		Spec:       terraformv1.WorkspaceSpec{Image: "test-image", WorkingDir: "/test"},
+	}
+
+	Context("Add variables", func() {
+		It("Should add plain variables to the ConfigMap", func() {
+			job := CreateJob(key, core.TFPlan, ws, false)
+			configMap, err := CreateConfigMap(key, backend, ws)
+			Expect(err).NotTo(HaveOccurred())
+
+			secret, err := AddVariables(job, configMap, Variables{Plain: map[string]string{"name": `say "hi"`}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(secret).Should(BeNil())
+			Expect(configMap.Data[VariablesKey]).Should(Equal(`{"name":"say \"hi\""}`))
+			Expect(job.Spec.Template.Spec.Volumes[0].ConfigMap.Items).Should(ContainElement(corev1.KeyToPath{
+				Key:  VariablesKey,
+				Path: "variables.auto.tfvars.json",
+			}))
+		})
+
+		It("Should keep sensitive variables out of the ConfigMap", func() {
+			job := CreateJob(key, core.TFPlan, ws, false)
+			configMap, err := CreateConfigMap(key, backend, ws)
+			Expect(err).NotTo(HaveOccurred())
+
+			secret, err := AddVariables(job, configMap, Variables{
+				Plain:     map[string]string{"name": "test"},
+				Sensitive: map[string]string{"db_password": "hunter2"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(configMap.Data[VariablesKey]).ShouldNot(ContainSubstring("hunter2"))
+			Expect(configMap.Data[TfVarsKey]).ShouldNot(ContainSubstring("hunter2"))
+			Expect(secret.Name).Should(Equal("test-run-variables"))
+			Expect(secret.Namespace).Should(Equal("test-namespace"))
+			Expect(string(secret.Data[SensitiveVariablesKey])).Should(Equal(`{"db_password":"hunter2"}`))
+
+			volume := job.Spec.Template.Spec.Volumes[0]
+			Expect(volume.Name).Should(Equal("config-map"))
+			Expect(volume.ConfigMap).Should(BeNil())
+			Expect(volume.Projected.Sources).Should(HaveLen(2))
+			Expect(volume.Projected.Sources[0].ConfigMap.Name).Should(Equal("test-run"))
+			Expect(volume.Projected.Sources[0].ConfigMap.Items).Should(ContainElement(corev1.KeyToPath{
+				Key:  VariablesKey,
+				Path: "variables.auto.tfvars.json",
+			}))
+			Expect(volume.Projected.Sources[1].Secret.Name).Should(Equal("test-run-variables"))
+			Expect(volume.Projected.Sources[1].Secret.Items).Should(Equal([]corev1.KeyToPath{
+				{Key: SensitiveVariablesKey, Path: "sensitive.auto.tfvars.json"},
+			}))
+		})
+
+		It("Should not override the variables set by the controller", func() {
+			job := CreateJob(key, core.TFPlan, ws, false)
+			configMap, err := CreateConfigMap(key, backend, ws)
+			Expect(err).NotTo(HaveOccurred())
+
+			secret, err := AddVariables(job, configMap, Variables{
+				Plain:     map[string]string{"name": "test", "access_key": "other", "state_bucket_name": "other"},
+				Sensitive: map[string]string{"secret_key": "other", "network_workspace_namespace": "other"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(secret).Should(BeNil())
+			Expect(configMap.Data[VariablesKey]).Should(Equal(`{"name":"test"}`))
+		})
+
+		It("Should leave the job alone without variables", func() {
+			job := CreateJob(key, core.TFPlan, ws, false)
+			configMap, err := CreateConfigMap(key, backend, ws)
+			Expect(err).NotTo(HaveOccurred())
+			expected := job.DeepCopy()
+
+			secret, err := AddVariables(job, configMap, Variables{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(secret).Should(BeNil())
+			Expect(job).Should(Equal(expected))
+		})
+	})
+})
@@ -0,0 +1,70 @@
+package terraform
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/scipian/terraform-controller/pkg/core"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// PlanVolumeSize is the requested size of the PersistentVolumeClaim holding a saved plan
+const PlanVolumeSize = "1Gi"
+
+// +kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
+
+// CreatePlanVolumeClaim creates a PersistentVolumeClaim that keeps the saved plan between the plan and apply jobs of a Run
+func CreatePlanVolumeClaim(key types.NamespacedName) *corev1.PersistentVolumeClaim {
+	claim := &corev1.PersistentVolumeClaim{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "PersistentVolumeClaim",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      PlanVolumeClaimName(key.Name),
+			Namespace: key.Namespace,
+			Labels:    make(map[string]string),
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: resource.MustParse(PlanVolumeSize),
+				},
+			},
+		},
+	}
+	// Fall back to the cluster default storage class when none is configured
+	if storageClass := os.Getenv("SCIPIAN_PLAN_STORAGE_CLASS"); storageClass != "" {
+		claim.Spec.StorageClassName = &storageClass
+	}
+	return claim
+}
+
+// PlanVolumeClaimName returns the name of the PersistentVolumeClaim holding the saved plan of a Run
+func PlanVolumeClaimName(runName string) string {
+	return fmt.Sprintf("%s-plan", runName)
+}
+
+// AddPlanVolume mounts the given plan PersistentVolumeClaim into every container of the Job at core.PlanDir
+func AddPlanVolume(job *batchv1.Job, claimName string) {
+	podSpec := &job.Spec.Template.Spec
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: "plan",
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: claimName,
+			},
+		},
+	})
+	for i := range podSpec.Containers {
+		podSpec.Containers[i].VolumeMounts = append(podSpec.Containers[i].VolumeMounts, corev1.VolumeMount{
+			Name:      "plan",
+			MountPath: core.PlanDir,
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package terraform
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	terraformv1 "github.com/scipian/terraform-controller/api/v1"
+	"github.com/scipian/terraform-controller/pkg/core"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("Plan volume", func() {
+	key := types.NamespacedName{Namespace: "test-namespace", Name: "test-run"}
+
+	Context("Create plan volume claim", func() {
+		It("Should request a ReadWriteOnce volume named after the run", func() {
+			claim := CreatePlanVolumeClaim(key)
+			Expect(claim.Name).Should(Equal("test-run-plan"))
+			Expect(claim.Namespace).Should(Equal("test-namespace"))
+			Expect(claim.Spec.AccessModes).Should(ConsistOf(corev1.ReadWriteOnce))
+			Expect(claim.Spec.Resources.Requests[corev1.ResourceStorage]).Should(Equal(resource.MustParse(PlanVolumeSize)))
+			Expect(claim.Spec.StorageClassName).Should(BeNil())
+		})
+	})
+
+	Context("Add plan volume", func() {
+		It("Should mount the claim into the terraform container", func() {
+			ws := &terraformv1.Workspace{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-ws"},
+				Spec:       terraformv1.WorkspaceSpec{Image: "test-image", WorkingDir: "/test"},
+			}
+			job := CreateJob(key, core.TFPlanOnly, ws, false)
+			AddPlanVolume(job, "test-run-plan")
+
+			podSpec := job.Spec.Template.Spec
+			Expect(podSpec.Volumes).Should(ContainElement(corev1.Volume{
+				Name: "plan",
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "test-run-plan"},
+				},
+			}))
+			Expect(podSpec.Containers[0].VolumeMounts).Should(ContainElement(corev1.VolumeMount{
+				Name:      "plan",
+				MountPath: core.PlanDir,
+			}))
+		})
+	})
+})